@@ -0,0 +1,58 @@
+// Copyright (c) 2015 Matt Borgerson
+// 
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// 
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+// 
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+
+package truncatehtml
+
+import (
+    "fmt"
+)
+
+// FragmentError is returned by TruncateHtmlAll when one of the fragments
+// cannot be truncated.
+type FragmentError struct {
+    Index int   // Index of the fragment that failed
+    Err   error // The error returned for that fragment
+}
+
+func (e *FragmentError) Error() string {
+    return fmt.Sprintf("fragment %d: %s", e.Index, e.Err.Error())
+}
+
+// Unwrap returns the underlying error, so that errors.Is(err,
+// UnbalancedTagsError) works on a FragmentError.
+func (e *FragmentError) Unwrap() error {
+    return e.Err
+}
+
+// TruncateHtmlAll truncates each of fragments independently with TruncateHtml.
+// It stops at the first fragment that fails and returns a *FragmentError
+// holding that fragment's index, along with the fragments truncated so far.
+func TruncateHtmlAll(fragments [][]byte, maxlen int, ellipsis string) ([][]byte, error) {
+    output := make([][]byte, 0, len(fragments))
+    for i, fragment := range fragments {
+        truncated, err := TruncateHtml(fragment, maxlen, ellipsis)
+        if err != nil {
+            return output, &FragmentError{i, err}
+        }
+        output = append(output, truncated)
+    }
+    return output, nil
+}
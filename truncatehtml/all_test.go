@@ -0,0 +1,56 @@
+package truncatehtml
+
+import (
+  "errors"
+  "testing"
+)
+
+// TestTruncateHtmlAll checks that each fragment is truncated independently.
+func TestTruncateHtmlAll(t *testing.T) {
+  in := []string{"<p>Hello world</p>", "Short", "", "<b>Bold <i>text</i></b>"}
+  want := []string{"<p>Hello...</p>", "Short...", "", "<b>Bold <i>t...</i></b>"}
+
+  fragments := make([][]byte, len(in))
+  for i, s := range in {
+    fragments[i] = []byte(s)
+  }
+
+  out, err := TruncateHtmlAll(fragments, 5, "...")
+  if err != nil {
+    t.Fatalf("Got error calling TruncateHtmlAll(%q, 5, \"...\"). Error: %s", in, err.Error())
+  }
+  if len(out) != len(want) {
+    t.Fatalf("TruncateHtmlAll(%q, 5, \"...\") returned %d fragments, want %d", in, len(out), len(want))
+  }
+  for i := range want {
+    if string(out[i]) != want[i] {
+      t.Errorf("TruncateHtmlAll(%q, 5, \"...\")[%d] == %q, want %q", in, i, out[i], want[i])
+    }
+  }
+}
+
+// TestTruncateHtmlAllError checks that TruncateHtmlAll stops at the first
+// fragment with unbalanced tags and reports its index.
+func TestTruncateHtmlAllError(t *testing.T) {
+  in := []string{"<p>one</p>", "<p>two</i></p>", "<p>three</b></p>"}
+
+  fragments := make([][]byte, len(in))
+  for i, s := range in {
+    fragments[i] = []byte(s)
+  }
+
+  out, err := TruncateHtmlAll(fragments, 100, "")
+  var fragmentErr *FragmentError
+  if !errors.As(err, &fragmentErr) {
+    t.Fatalf("TruncateHtmlAll(%q, 100, \"\") returned error %v, want a *FragmentError", in, err)
+  }
+  if fragmentErr.Index != 1 {
+    t.Errorf("TruncateHtmlAll(%q, 100, \"\") failed at fragment %d, want 1", in, fragmentErr.Index)
+  }
+  if !errors.Is(err, UnbalancedTagsError) {
+    t.Errorf("TruncateHtmlAll(%q, 100, \"\") returned error %v, want UnbalancedTagsError", in, err)
+  }
+  if len(out) != 1 || string(out[0]) != "<p>one</p>" {
+    t.Errorf("TruncateHtmlAll(%q, 100, \"\") == %q, want [\"<p>one</p>\"]", in, out)
+  }
+}
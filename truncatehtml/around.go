@@ -0,0 +1,127 @@
+// Copyright (c) 2015 Matt Borgerson
+// 
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// 
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+// 
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+
+package truncatehtml
+
+import (
+    "html"
+    "math"
+    "strings"
+)
+
+// TruncateHtmlAround returns a window of maxlen visible characters of buf
+// centered on the first occurrence of term in the visible text, as for a
+// search result snippet. The term is matched case-insensitively and may span
+// markup. Elements that are open where the window starts are re-opened with
+// their original start tags, and ellipsis is added wherever content was
+// dropped, so the output is valid HTML. If term is empty or not found, the
+// window starts at the beginning of buf.
+func TruncateHtmlAround(buf []byte, term string, maxlen int, ellipsis string) ([]byte, error) {
+    opts := Options{EllipsisOnlyWhenTruncated: true}
+
+    // First pass: collect the text characters along with their offsets and
+    // the number of visible characters before each of them.
+    var text []rune
+    var offsets, before []int
+    t := newTruncator(buf, math.MaxInt, Options{})
+    t.discard = true
+    for t.pos < len(buf) {
+        tok := readToken(buf, t.pos)
+        if tok.kind == textToken || tok.kind == entityToken {
+            text = append(text, tok.r)
+            offsets = append(offsets, tok.start)
+            before = append(before, t.visible)
+        }
+        if err := t.step(); err != nil {
+            return nil, err
+        }
+    }
+    total := t.visible
+
+    match := indexFold(text, []rune(term))
+    if match < 0 || maxlen <= 0 {
+        return TruncateHtmlWithOptions(buf, maxlen, ellipsis, opts)
+    }
+
+    // Center the term in the window, keeping the window full where possible.
+    termEnd := total
+    if end := match + len([]rune(term)); end < len(before) {
+        termEnd = before[end]
+    }
+    termStart := before[match]
+    start := termStart - (maxlen - (termEnd - termStart)) / 2
+    if start > termStart {
+        // The term is longer than the window, so show its beginning.
+        start = termStart
+    }
+    if start > total - maxlen {
+        start = total - maxlen
+    }
+    if start <= 0 {
+        return TruncateHtmlWithOptions(buf, maxlen, ellipsis, opts)
+    }
+
+    // Find the first visible character of the window.
+    offset := 0
+    for i := range before {
+        if before[i] == start && t.counts(readToken(buf, offsets[i])) {
+            offset = offsets[i]
+            break
+        }
+    }
+
+    // Second pass: find the elements open at the window start, re-open them
+    // and continue truncating from there.
+    t = newTruncator(buf, math.MaxInt, opts)
+    t.discard = true
+    for t.pos < offset {
+        if err := t.step(); err != nil {
+            return nil, err
+        }
+    }
+    t.discard = false
+    for _, tag := range t.stack {
+        t.out = append(t.out, tag.raw...)
+    }
+    t.out = append(t.out, html.EscapeString(ellipsis)...)
+    t.visible = 0
+    t.maxlen = maxlen
+
+    result, err := t.run(ellipsis)
+    if err != nil {
+        return nil, err
+    }
+    return result.output, nil
+}
+
+// indexFold returns the index of the first case-insensitive match of term in
+// text, or -1 if there is none.
+func indexFold(text, term []rune) int {
+    if len(term) == 0 {
+        return -1
+    }
+    for i := 0; i+len(term) <= len(text); i++ {
+        if strings.EqualFold(string(text[i:i+len(term)]), string(term)) {
+            return i
+        }
+    }
+    return -1
+}
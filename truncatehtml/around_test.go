@@ -0,0 +1,66 @@
+package truncatehtml
+
+import "testing"
+
+// TestTruncateHtmlAround checks that the window is centered on the term and
+// that elements open at the window start are re-opened.
+func TestTruncateHtmlAround(t *testing.T) {
+  in := "<div><p>The <b>quick brown fox</b> jumps over the lazy dog</p></div>"
+  cases := []struct {
+      term string
+      limit int
+      want string
+  }{
+    {
+      "fox",
+      9,
+      "<div><p><b>…own fox</b> jum…</p></div>",
+    },
+    {
+      "FOX",
+      9,
+      "<div><p><b>…own fox</b> jum…</p></div>",
+    },
+    {
+      "fox jumps",
+      10,
+      "<div><p><b>…n fox</b> jumps o…</p></div>",
+    },
+    {
+      "the",
+      6,
+      "<div><p>The <b>qui…</b></p></div>",
+    },
+    {
+      "dog",
+      6,
+      "<div><p>…azy dog</p></div>",
+    },
+    {
+      "quick brown",
+      4,
+      "<div><p><b>…quic…</b></p></div>",
+    },
+    {
+      "cat",
+      6,
+      "<div><p>The <b>qui…</b></p></div>",
+    },
+    {
+      "fox",
+      100,
+      "<div><p>The <b>quick brown fox</b> jumps over the lazy dog</p></div>",
+    },
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtmlAround([]byte(in), c.term, c.limit, "…")
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlAround(%q, %q, %d, \"…\"). Error: %s", in, c.term, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlAround(%q, %q, %d, \"…\") == %q, want %q", in, c.term, c.limit, got, c.want)
+    }
+  }
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2015 Matt Borgerson
+// 
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// 
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+// 
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+
+package truncatehtml
+
+// Attribute is an attribute of a start tag. The name is lowercased and the
+// value has its character references decoded.
+type Attribute struct {
+    Name  string
+    Value string
+}
+
+// ClosedElement describes an element that was open at the cut and was closed
+// by truncation.
+type ClosedElement struct {
+    Name       string
+    Attributes []Attribute // In the order they appeared in the start tag
+    StartTag   string      // The start tag exactly as it appeared in the input
+}
+
+// TruncateHtmlAudit truncates buf like TruncateHtml and also reports the
+// elements that were closed by truncation, outermost first, along with the
+// attributes of their start tags. Callers that re-open those elements later,
+// for example to render the rest of a split document, can use the report to
+// restore them faithfully.
+func TruncateHtmlAudit(buf []byte, maxlen int, ellipsis string) ([]byte, []ClosedElement, error) {
+    result, err := truncate(buf, maxlen, ellipsis, Options{})
+    if err != nil {
+        return nil, nil, err
+    }
+
+    closed := make([]ClosedElement, len(result.open))
+    for i, tag := range result.open {
+        closed[i] = ClosedElement{Name: tag.name, StartTag: string(tag.raw)}
+        for _, attr := range tagAttributes(tag.raw) {
+            closed[i].Attributes = append(closed[i].Attributes, Attribute{attr.name, attr.value})
+        }
+    }
+    return result.output, closed, nil
+}
@@ -0,0 +1,54 @@
+package truncatehtml
+
+import (
+  "reflect"
+  "testing"
+)
+
+// TestTruncateHtmlAudit checks the report of elements closed by truncation.
+func TestTruncateHtmlAudit(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      want string
+      wantClosed []ClosedElement
+  }{
+    {
+      "<div class=\"post\" id=p1><p>Hello <a href=\"/x?a=1&amp;b=2\" title='Go'>world</a></p></div>",
+      7,
+      "<div class=\"post\" id=p1><p>Hello <a href=\"/x?a=1&amp;b=2\" title='Go'>wo...</a></p></div>",
+      []ClosedElement{
+        {"div", []Attribute{{"class", "post"}, {"id", "p1"}}, "<div class=\"post\" id=p1>"},
+        {"p", nil, "<p>"},
+        {"a", []Attribute{{"href", "/x?a=1&b=2"}, {"title", "Go"}}, "<a href=\"/x?a=1&amp;b=2\" title='Go'>"},
+      },
+    },
+    {
+      "<P DATA-X=1 hidden>Hello</P>",
+      2,
+      "<P DATA-X=1 hidden>He...</P>",
+      []ClosedElement{
+        {"p", []Attribute{{"data-x", "1"}, {"hidden", ""}}, "<P DATA-X=1 hidden>"},
+      },
+    },
+    {
+      "<p class=\"x\">Hello</p>",
+      100,
+      "<p class=\"x\">Hello</p>...",
+      []ClosedElement{},
+    },
+  }
+
+  for _, c := range cases {
+    out, closed, err := TruncateHtmlAudit([]byte(c.in), c.limit, "...")
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlAudit(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if string(out) != c.want {
+      t.Errorf("TruncateHtmlAudit(%q, %d, \"...\") == %q, want %q", c.in, c.limit, out, c.want)
+    }
+    if !reflect.DeepEqual(closed, c.wantClosed) {
+      t.Errorf("TruncateHtmlAudit(%q, %d, \"...\") closed %+v, want %+v", c.in, c.limit, closed, c.wantClosed)
+    }
+  }
+}
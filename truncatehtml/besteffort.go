@@ -0,0 +1,42 @@
+// Copyright (c) 2015 Matt Borgerson
+// 
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// 
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+// 
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+
+package truncatehtml
+
+import (
+    "html"
+)
+
+// TruncateHtmlBestEffort is like TruncateHtml, except that when unbalanced
+// tags are found it returns the output built up to that point along with
+// UnbalancedTagsError, instead of nil. The partial output ends with the
+// ellipsis and has its open elements closed, so it is still balanced.
+func TruncateHtmlBestEffort(buf []byte, maxlen int, ellipsis string) ([]byte, error) {
+    t := newTruncator(buf, maxlen, Options{})
+    result, err := t.run(ellipsis)
+    if err == nil {
+        return result.output, nil
+    }
+
+    output := append(t.out, html.EscapeString(ellipsis)...)
+    output = appendClosers(output, t.stack)
+    return output, err
+}
@@ -0,0 +1,53 @@
+package truncatehtml
+
+import "testing"
+
+// TestTruncateHtmlBestEffort checks the partial output returned with an
+// error.
+func TestTruncateHtmlBestEffort(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      want string
+      wantErr error
+  }{
+    {
+      "<p>Hello <b>world</b></p>",
+      7,
+      "<p>Hello <b>wo...</b></p>",
+      nil,
+    },
+    {
+      "<div><p>Hello <b>world</i> again</b></p></div>",
+      100,
+      "<div><p>Hello <b>world...</b></p></div>",
+      UnbalancedTagsError,
+    },
+    {
+      "<p>One</p></div><p>Two</p>",
+      100,
+      "<p>One</p>...",
+      UnbalancedTagsError,
+    },
+    {
+      "<p>One <b>two</i></b></p>",
+      3,
+      "<p>One...</p>",
+      nil,
+    },
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtmlBestEffort([]byte(c.in), c.limit, "...")
+    got := string(out)
+    if err != c.wantErr {
+      t.Errorf("TruncateHtmlBestEffort(%q, %d, \"...\") returned error %v, want %v", c.in, c.limit, err, c.wantErr)
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlBestEffort(%q, %d, \"...\") == %q, want %q", c.in, c.limit, got, c.want)
+    }
+    if !isBalanced(out) {
+      t.Errorf("TruncateHtmlBestEffort(%q, %d, \"...\") == %q, which is not balanced", c.in, c.limit, got)
+    }
+  }
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2015 Matt Borgerson
+// 
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// 
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+// 
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package truncatehtml
+
+import (
+    "math"
+)
+
+// TruncateHtmlBody truncates a full HTML document, keeping everything up to
+// and including the <body> start tag, such as the <head> with its charset
+// <meta>, untouched. Only the contents of the body count toward maxlen. The
+// body and any elements around it are closed again after the cut, and
+// ellipsis is added only if content was dropped. If buf has no <body> start
+// tag, it is truncated as a fragment, as by TruncateHtml.
+func TruncateHtmlBody(buf []byte, maxlen int, ellipsis string) ([]byte, error) {
+    // Copy everything up to the body, keeping track of the open elements.
+    t := newTruncator(buf, math.MaxInt, Options{EllipsisOnlyWhenTruncated: true})
+    for {
+        if t.pos == len(buf) {
+            return TruncateHtml(buf, maxlen, ellipsis)
+        }
+        tok := readToken(buf, t.pos)
+        if err := t.step(); err != nil {
+            return nil, err
+        }
+        if tok.kind == startTagToken && tok.name == "body" {
+            break
+        }
+    }
+
+    t.visible = 0
+    t.maxlen = maxlen
+    if maxlen <= 0 {
+        return appendClosers(t.out, t.stack), nil
+    }
+    result, err := t.run(ellipsis)
+    if err != nil {
+        return nil, err
+    }
+    return result.output, nil
+}
@@ -0,0 +1,60 @@
+package truncatehtml
+
+import "testing"
+
+// TestTruncateHtmlBody checks that only the content of the body element is
+// truncated, and that the markup around it is kept.
+func TestTruncateHtmlBody(t *testing.T) {
+  head := "<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>A long title</title></head>"
+  cases := []struct {
+      in string
+      limit int
+      want string
+  }{
+    {
+      head + "<body><p>Hello world</p></body></html>",
+      5,
+      head + "<body><p>Hello...</p></body></html>",
+    },
+    {
+      head + "<body><p>Hello world</p></body></html>",
+      100,
+      head + "<body><p>Hello world</p></body></html>",
+    },
+    {
+      head + "<body class=\"x\"><p>Hello</p><p>world</p></body></html>\n",
+      5,
+      head + "<body class=\"x\"><p>Hello...</p></body></html>",
+    },
+    {
+      head + "<body><p>Hello world</p></body></html>",
+      0,
+      head + "<body></body></html>",
+    },
+    {
+      head + "<BODY><p>Hello world</p>",
+      7,
+      head + "<BODY><p>Hello wo...</p></BODY></html>",
+    },
+    {
+      "<p>No body here</p>",
+      2,
+      "<p>No...</p>",
+    },
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtmlBody([]byte(c.in), c.limit, "...")
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlBody(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlBody(%q, %d, \"...\") == %q, want %q", c.in, c.limit, got, c.want)
+    }
+  }
+
+  if _, err := TruncateHtmlBody([]byte("<html><head></b><body>Hi</body></html>"), 10, ""); err != UnbalancedTagsError {
+    t.Errorf("TruncateHtmlBody with unbalanced tags returned error %v, want %v", err, UnbalancedTagsError)
+  }
+}
@@ -0,0 +1,39 @@
+// Copyright (c) 2015 Matt Borgerson
+// 
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// 
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+// 
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+
+package truncatehtml
+
+// TruncateHtmlClosed truncates buf like TruncateHtml and also returns the
+// names of the elements that truncation closed. They are in the order their
+// closing tags were written, innermost first, so for <a><b><c> cut inside
+// <c> the output ends in </c></b></a> and closed is ["c", "b", "a"].
+func TruncateHtmlClosed(buf []byte, maxlen int, ellipsis string) ([]byte, []string, error) {
+    result, err := truncate(buf, maxlen, ellipsis, Options{})
+    if err != nil {
+        return nil, nil, err
+    }
+
+    closed := make([]string, 0, len(result.open))
+    for i := len(result.open)-1; i >= 0; i-- {
+        closed = append(closed, result.open[i].name)
+    }
+    return result.output, closed, nil
+}
@@ -0,0 +1,58 @@
+package truncatehtml
+
+import (
+  "strings"
+  "testing"
+)
+
+// TestTruncateHtmlClosed checks that the closed elements are listed in the
+// order their closing tags are written.
+func TestTruncateHtmlClosed(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      want string
+      wantClosed []string
+  }{
+    {
+      "<a href=\"/x\"><b><i>nested text</i></b></a>",
+      3,
+      "<a href=\"/x\"><b><i>nes...</i></b></a>",
+      []string{"i", "b", "a"},
+    },
+    {
+      "<div><p>One <em>two</em> three</p></div>",
+      5,
+      "<div><p>One <em>tw...</em></p></div>",
+      []string{"em", "p", "div"},
+    },
+    {
+      "<p>Short</p>",
+      100,
+      "<p>Short</p>...",
+      []string{},
+    },
+  }
+
+  for _, c := range cases {
+    out, closed, err := TruncateHtmlClosed([]byte(c.in), c.limit, "...")
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlClosed(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if string(out) != c.want {
+      t.Errorf("TruncateHtmlClosed(%q, %d, \"...\") == %q, want %q", c.in, c.limit, out, c.want)
+    }
+    if strings.Join(closed, ",") != strings.Join(c.wantClosed, ",") {
+      t.Errorf("TruncateHtmlClosed(%q, %d, \"...\") closed %q, want %q", c.in, c.limit, closed, c.wantClosed)
+    }
+
+    // The closing tags at the end of the output appear in the same order.
+    var closers string
+    for _, name := range closed {
+      closers += "</" + name + ">"
+    }
+    if !strings.HasSuffix(string(out), closers) {
+      t.Errorf("TruncateHtmlClosed(%q, %d, \"...\") == %q, which does not end with %q", c.in, c.limit, out, closers)
+    }
+  }
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2015 Matt Borgerson
+// 
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// 
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+// 
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+
+package truncatehtml
+
+import (
+    "html"
+)
+
+// TruncateHtmlDual truncates buf like TruncateHtml and also returns the same
+// truncated content as plain text, for example for the text part of an email.
+// The text has all tags and comments removed and entities decoded, but its
+// whitespace is left as it appeared in buf. The ellipsis is appended to the
+// text as is.
+func TruncateHtmlDual(buf []byte, maxlen int, ellipsis string) (htmlOut []byte, textOut []byte, err error) {
+    result, err := truncate(buf, maxlen, ellipsis, Options{})
+    if err != nil {
+        return nil, nil, err
+    }
+
+    content := result.output[:result.content]
+    textOut = make([]byte, 0, len(content)+len(ellipsis))
+    for pos := 0; pos < len(content); {
+        tok := readToken(content, pos)
+        switch tok.kind {
+        case textToken:
+            textOut = append(textOut, content[tok.start:tok.end]...)
+        case entityToken:
+            textOut = append(textOut, html.UnescapeString(string(content[tok.start:tok.end]))...)
+        }
+        pos = tok.end
+    }
+    textOut = append(textOut, ellipsis...)
+    return result.output, textOut, nil
+}
@@ -0,0 +1,70 @@
+package truncatehtml
+
+import "testing"
+
+// TestTruncateHtmlDual checks that the HTML and plain text outputs hold the
+// same content.
+func TestTruncateHtmlDual(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      wantHtml string
+      wantText string
+  }{
+    {
+      "<p>Fish &amp; chips &mdash; &pound;5</p>",
+      100,
+      "<p>Fish &amp; chips &mdash; &pound;5</p>...",
+      "Fish & chips — £5...",
+    },
+    {
+      "<p>Fish &amp; chips &mdash; &pound;5</p>",
+      6,
+      "<p>Fish &amp; c...</p>",
+      "Fish & c...",
+    },
+    {
+      "<p>&lt;b&gt; is <b>bold</b></p><!-- note --><p>&#169; 2024</p>",
+      7,
+      "<p>&lt;b&gt; is <b>bo...</b></p>",
+      "<b> is bo...",
+    },
+    {
+      "<p>&lt;b&gt; is <b>bold</b></p><!-- note --><p>&#169; 2024</p>",
+      10,
+      "<p>&lt;b&gt; is <b>bold</b></p><!-- note --><p>&#169;...</p>",
+      "<b> is bold©...",
+    },
+    {
+      "<p>Caf&eacute; &amp; Cr&egrave;me</p>",
+      11,
+      "<p>Caf&eacute; &amp; Cr&egrave;me</p>...",
+      "Café & Crème...",
+    },
+    {
+      "Plain text",
+      5,
+      "Plain...",
+      "Plain...",
+    },
+  }
+
+  for _, c := range cases {
+    htmlOut, textOut, err := TruncateHtmlDual([]byte(c.in), c.limit, "...")
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlDual(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if string(htmlOut) != c.wantHtml {
+      t.Errorf("TruncateHtmlDual(%q, %d, \"...\") html == %q, want %q", c.in, c.limit, htmlOut, c.wantHtml)
+    }
+    if string(textOut) != c.wantText {
+      t.Errorf("TruncateHtmlDual(%q, %d, \"...\") text == %q, want %q", c.in, c.limit, textOut, c.wantText)
+    }
+
+    // The HTML output must match TruncateHtml exactly.
+    want, _ := TruncateHtml([]byte(c.in), c.limit, "...")
+    if string(htmlOut) != string(want) {
+      t.Errorf("TruncateHtmlDual(%q, %d, \"...\") html == %q, TruncateHtml gives %q", c.in, c.limit, htmlOut, want)
+    }
+  }
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2015 Matt Borgerson
+// 
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// 
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+// 
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package truncatehtml
+
+import (
+    "html"
+)
+
+// TruncateHtmlElementBoundary truncates buf like TruncateHtml, except that
+// the output never ends partway through an element. If the limit is reached
+// inside an element, the output backs up to the end of the last element that
+// was closed before it, at any depth, or to the end of the last text outside
+// of every element; the elements still open there are closed after the
+// ellipsis. The ellipsis is placed only when content was dropped.
+func TruncateHtmlElementBoundary(buf []byte, maxlen int, ellipsis string) ([]byte, error) {
+    t := newTruncator(buf, maxlen, Options{})
+    boundary := t.save()
+    implied := 0 // Elements at the boundary that end without an end tag
+    for t.pos < len(buf) && !t.stopped {
+        tok := readToken(buf, t.pos)
+
+        // A start tag that implies the end of open elements, such as <li>
+        // after an open li, ends them just like their end tags would.
+        ends := 0
+        if tok.kind == startTagToken && !tok.selfClosing && !voidElements[tok.name] {
+            for ends < len(t.stack) && impliedEnds[tok.name][t.stack[len(t.stack)-1-ends].name] {
+                ends++
+            }
+        }
+        if ends > 0 {
+            boundary = t.save()
+            implied = ends
+        }
+
+        if err := t.step(); err != nil {
+            return nil, err
+        }
+        if t.pos == tok.end && (tok.kind == endTagToken || len(t.stack) == 0) {
+            boundary = t.save()
+            implied = 0
+        }
+    }
+    if !t.moreVisible() {
+        return appendClosers(t.out, t.stack), nil
+    }
+
+    if len(t.stack) > 0 {
+        t.restore(boundary)
+        t.out = appendClosers(t.out, t.stack[len(t.stack)-implied:])
+        t.stack = t.stack[:len(t.stack)-implied]
+    }
+    output := append(t.out, html.EscapeString(ellipsis)...)
+    return appendClosers(output, t.stack), nil
+}
@@ -0,0 +1,79 @@
+package truncatehtml
+
+import "testing"
+
+// TestTruncateHtmlElementBoundary checks that the output backs up to the end
+// of the last complete element rather than cutting one.
+func TestTruncateHtmlElementBoundary(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      want string
+  }{
+    {
+      "<p>Hello <b>big</b> wide world</p>",
+      12,
+      "<p>Hello <b>big</b>...</p>",
+    },
+    {
+      "<p>Hello <b>big <i>bold</i> text</b> end</p>",
+      11,
+      "...",
+    },
+    {
+      "<p>Hello <b>big <i>bold</i> text</b> end</p>",
+      12,
+      "<p>Hello <b>big <i>bold</i>...</b></p>",
+    },
+    {
+      "<p>Hello <b>big <i>bold</i> text</b> end</p>",
+      16,
+      "<p>Hello <b>big <i>bold</i> text</b>...</p>",
+    },
+    {
+      "<p>Hello <b>big <i>bold</i> text</b> end</p>",
+      100,
+      "<p>Hello <b>big <i>bold</i> text</b> end</p>",
+    },
+    {
+      "<p>One</p><p>Two <em>three</em></p>",
+      6,
+      "<p>One</p>...",
+    },
+    {
+      "<p>Hello world</p>",
+      5,
+      "...",
+    },
+    {
+      "<ul><li>ab<li>cdef</ul>",
+      3,
+      "<ul><li>ab</li>...</ul>",
+    },
+    {
+      "<ul><li>ab</li><li>cdef</ul>",
+      3,
+      "<ul><li>ab</li>...</ul>",
+    },
+    {
+      "Plain <b>bold</b> text",
+      11,
+      "Plain <b>bold</b> te...",
+    },
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtmlElementBoundary([]byte(c.in), c.limit, "...")
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlElementBoundary(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlElementBoundary(%q, %d, \"...\") == %q, want %q", c.in, c.limit, got, c.want)
+    }
+  }
+
+  if _, err := TruncateHtmlElementBoundary([]byte("<p>Bad</b>"), 10, "..."); err != UnbalancedTagsError {
+    t.Errorf("TruncateHtmlElementBoundary with unbalanced tags returned error %v, want %v", err, UnbalancedTagsError)
+  }
+}
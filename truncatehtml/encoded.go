@@ -0,0 +1,84 @@
+// Copyright (c) 2015 Matt Borgerson
+// 
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// 
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+// 
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package truncatehtml
+
+import (
+    "bytes"
+    "html"
+)
+
+// TruncateEncodedHtml truncates HTML that has itself been entity-encoded,
+// such as the <description> of an RSS item holding "&lt;p&gt;Hello&lt;/p&gt;".
+// One level of character references is decoded, the HTML is truncated as by
+// TruncateHtml, and the result is encoded again. The part of buf that is kept
+// is copied as it was written, so that "&quot;" and a bare "'" come back
+// unchanged; only the ellipsis and the closing tags added are newly encoded.
+func TruncateEncodedHtml(buf []byte, maxlen int, ellipsis string) ([]byte, error) {
+    decoded, source := decodeReferences(buf)
+    result, err := truncate(decoded, maxlen, ellipsis, Options{})
+    if err != nil {
+        return nil, err
+    }
+
+    // Copy each token of the decoded input that was kept from where it came
+    // from in buf. Tokens that truncation dropped, such as a </br>, are
+    // skipped.
+    kept := result.output[:result.content]
+    output := make([]byte, 0, len(buf))
+    copied := 0
+    for pos := 0; pos < result.cut && copied < len(kept); {
+        _, end, _, _ := scanToken(decoded, pos)
+        if bytes.HasPrefix(kept[copied:], decoded[pos:end]) {
+            output = append(output, buf[source[pos]:source[end]]...)
+            copied += end-pos
+        }
+        pos = end
+    }
+    output = append(output, html.EscapeString(string(kept[copied:]))...)
+    return append(output, html.EscapeString(string(result.output[result.content:]))...), nil
+}
+
+// decodeReferences decodes the character references in buf. It also returns,
+// for each offset in the decoded text and for its end, the offset in buf it
+// was decoded from.
+func decodeReferences(buf []byte) ([]byte, []int) {
+    decoded := make([]byte, 0, len(buf))
+    source := make([]int, 0, len(buf)+1)
+    for pos := 0; pos < len(buf); {
+        n := 0
+        if buf[pos] == '&' {
+            n = entityLen(buf[pos:])
+        }
+        if n == 0 {
+            decoded = append(decoded, buf[pos])
+            source = append(source, pos)
+            pos++
+            continue
+        }
+        text := html.UnescapeString(string(buf[pos:pos+n]))
+        for i := 0; i < len(text); i++ {
+            source = append(source, pos)
+        }
+        decoded = append(decoded, text...)
+        pos += n
+    }
+    return decoded, append(source, len(buf))
+}
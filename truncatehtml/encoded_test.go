@@ -0,0 +1,64 @@
+package truncatehtml
+
+import "testing"
+
+// TestTruncateEncodedHtml checks that HTML-escaped markup is truncated as
+// markup and returned escaped again.
+func TestTruncateEncodedHtml(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      want string
+  }{
+    {
+      "&lt;p&gt;Hello &lt;b&gt;world&lt;/b&gt;&lt;/p&gt;",
+      7,
+      "&lt;p&gt;Hello &lt;b&gt;wo...&lt;/b&gt;&lt;/p&gt;",
+    },
+    {
+      "&lt;p&gt;Fish &amp;amp; chips&lt;/p&gt;",
+      5,
+      "&lt;p&gt;Fish &amp;amp;...&lt;/p&gt;",
+    },
+    {
+      "&lt;a href=&quot;x&quot;&gt;Link&lt;/a&gt; text",
+      2,
+      "&lt;a href=&quot;x&quot;&gt;Li...&lt;/a&gt;",
+    },
+    {
+      "Plain text",
+      5,
+      "Plain...",
+    },
+    {
+      "&lt;p title='it&#39;s'&gt;Tom's &quot;cat&quot;&lt;/p&gt;",
+      10,
+      "&lt;p title='it&#39;s'&gt;Tom's &quot;cat&quot;...&lt;/p&gt;",
+    },
+    {
+      "&lt;p&gt;a&lt;/br&gt;b&#x3C;/p&gt; tail",
+      2,
+      "&lt;p&gt;ab...&lt;/p&gt;",
+    },
+    {
+      "&lt;p&gt;Caf&eacute; &amp tea&lt;/p&gt;",
+      100,
+      "&lt;p&gt;Caf&eacute; &amp tea&lt;/p&gt;...",
+    },
+  }
+
+  for _, c := range cases {
+    out, err := TruncateEncodedHtml([]byte(c.in), c.limit, "...")
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateEncodedHtml(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateEncodedHtml(%q, %d, \"...\") == %q, want %q", c.in, c.limit, got, c.want)
+    }
+  }
+
+  if _, err := TruncateEncodedHtml([]byte("&lt;p&gt;Bad&lt;/b&gt;"), 10, ""); err != UnbalancedTagsError {
+    t.Errorf("TruncateEncodedHtml with unbalanced tags returned error %v, want %v", err, UnbalancedTagsError)
+  }
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2015 Matt Borgerson
+// 
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// 
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+// 
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+
+package truncatehtml
+
+import (
+    "bytes"
+)
+
+var cdataStart = []byte("<![CDATA[")
+var cdataEnd = []byte("]]>")
+
+// TruncateFeedContent truncates the HTML payload of an RSS or Atom entry, such
+// as the body of a content:encoded element. If buf is wrapped in a CDATA
+// section, the wrapper is stripped before truncation and the truncated HTML is
+// wrapped in a new CDATA section. Otherwise buf is truncated as plain HTML.
+func TruncateFeedContent(buf []byte, maxlen int, ellipsis string) ([]byte, error) {
+    trimmed := bytes.TrimSpace(buf)
+    if len(trimmed) < len(cdataStart)+len(cdataEnd) ||
+       !bytes.HasPrefix(trimmed, cdataStart) ||
+       !bytes.HasSuffix(trimmed, cdataEnd) {
+        return TruncateHtml(buf, maxlen, ellipsis)
+    }
+
+    inner := trimmed[len(cdataStart):len(trimmed)-len(cdataEnd)]
+    truncated, err := TruncateHtml(inner, maxlen, ellipsis)
+    if err != nil {
+        return nil, err
+    }
+
+    output := make([]byte, 0, len(cdataStart)+len(truncated)+len(cdataEnd))
+    output = append(output, cdataStart...)
+    output = append(output, truncated...)
+    output = append(output, cdataEnd...)
+    return output, nil
+}
@@ -0,0 +1,69 @@
+package truncatehtml
+
+import "testing"
+
+// TestTruncateFeedContent checks that CDATA-wrapped feed payloads are
+// truncated inside the wrapper.
+func TestTruncateFeedContent(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      ellipsis string
+      want string
+  }{
+    {
+      "<![CDATA[<p>Hello <b>world</b>, this is a post.</p>]]>",
+      8,
+      "...",
+      "<![CDATA[<p>Hello <b>wor...</b></p>]]>",
+    },
+    {
+      "\n  <![CDATA[<p>Short</p>]]>\n",
+      10,
+      "",
+      "<![CDATA[<p>Short</p>]]>",
+    },
+    {
+      "<![CDATA[]]>",
+      10,
+      "",
+      "<![CDATA[]]>",
+    },
+    {
+      "<![CDATA[<p>Hello world</p>]]>",
+      5,
+      "]]>",
+      "<![CDATA[<p>Hello]]&gt;</p>]]>",
+    },
+    {
+      "<p>Hello <b>world</b></p>",
+      7,
+      "",
+      "<p>Hello <b>wo</b></p>",
+    },
+  }
+
+  for _, c := range cases {
+    out, err := TruncateFeedContent([]byte(c.in), c.limit, c.ellipsis)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateFeedContent(%q, %d, %q). Error: %s", c.in, c.limit, c.ellipsis, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateFeedContent(%q, %d, %q) == %q, want %q", c.in, c.limit, c.ellipsis, got, c.want)
+    }
+  }
+}
+
+// TestTruncateFeedContentKeepsInput checks that the caller's buffer is not
+// modified when the payload is truncated in place.
+func TestTruncateFeedContentKeepsInput(t *testing.T) {
+  in := "<![CDATA[<p>Hello world</p>]]>"
+  buf := []byte(in)
+  if _, err := TruncateFeedContent(buf, 5, "..."); err != nil {
+    t.Fatalf("Got error calling TruncateFeedContent(%q, 5, \"...\"). Error: %s", in, err.Error())
+  }
+  if string(buf) != in {
+    t.Errorf("TruncateFeedContent modified its input: %q, want %q", buf, in)
+  }
+}
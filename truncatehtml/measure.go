@@ -0,0 +1,127 @@
+// Copyright (c) 2015 Matt Borgerson
+// 
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// 
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+// 
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+
+package truncatehtml
+
+import (
+    "math"
+    "unicode"
+)
+
+// WouldTruncate reports whether TruncateHtml would drop visible content from
+// buf, that is, whether its visible length exceeds maxlen. It builds no output
+// and stops scanning as soon as the answer is known, which makes it cheaper
+// than truncating and comparing. An error is returned only if unbalanced tags
+// are found before that point.
+func WouldTruncate(buf []byte, maxlen int) (bool, error) {
+    t := newTruncator(buf, maxlen, Options{})
+    t.discard = true
+    for t.pos < len(buf) && !t.stopped {
+        if err := t.step(); err != nil {
+            return false, err
+        }
+    }
+    return t.stopped, nil
+}
+
+// VisibleLength returns the number of visible characters in buf, counted the
+// way TruncateHtml counts them. It doesn't allocate, so it is cheap enough to
+// call before deciding whether to truncate. Tags are not checked for balance.
+func VisibleLength(buf []byte) int {
+    visible, _, _ := visibleLengthUpTo(buf, math.MaxInt)
+    return visible
+}
+
+// VisibleLengthUpTo counts the visible characters of buf the way TruncateHtml
+// does, but stops scanning once limit characters have been seen. It returns
+// the count, which is at most limit, and whether the limit was reached. Tags
+// are not checked for balance.
+func VisibleLengthUpTo(buf []byte, limit int) (int, bool) {
+    visible, reached, _ := visibleLengthUpTo(buf, limit)
+    return visible, reached
+}
+
+// visibleLengthUpTo does the work for VisibleLengthUpTo. It also returns the
+// number of bytes of buf that were scanned.
+func visibleLengthUpTo(buf []byte, limit int) (visible int, reached bool, scanned int) {
+    s := Scanner{buf: buf}
+    for visible < limit {
+        _, _, delta, ok := s.Next()
+        if !ok {
+            break
+        }
+        visible += delta
+    }
+    return visible, visible >= limit, s.pos
+}
+
+// zeroWidthEntities are the named character references for the characters
+// in zeroWidth.
+var zeroWidthEntities = map[string]rune{
+    "&ZeroWidthSpace;": '\u200b', "&NegativeVeryThinSpace;": '\u200b',
+    "&NegativeThinSpace;": '\u200b', "&NegativeMediumSpace;": '\u200b',
+    "&NegativeThickSpace;": '\u200b', "&zwnj;": '\u200c', "&zwj;": '\u200d',
+    "&NoBreak;": '\u2060',
+}
+
+// entityRune returns the character that the character reference entity
+// stands for, as far as CountMode.countsToken needs it, without allocating.
+// Numeric references are decoded the way html.UnescapeString decodes them,
+// which stops at the first non-digit. Named references other than those in
+// zeroWidthEntities, like references that decode to nothing, are returned as
+// '&', which is how readToken returns the ones it doesn't know.
+func entityRune(entity []byte) rune {
+    if entity[1] != '#' {
+        if r, ok := zeroWidthEntities[string(entity)]; ok {
+            return r
+        }
+        return '&'
+    }
+
+    i, base := 2, rune(10)
+    if entity[i] == 'x' || entity[i] == 'X' {
+        i, base = 3, 16
+    }
+    var r rune
+    digits := 0
+    for ; i < len(entity); i++ {
+        d := rune(-1)
+        switch c := entity[i]; {
+        case '0' <= c && c <= '9':
+            d = rune(c-'0')
+        case base == 16 && 'a' <= c && c <= 'f':
+            d = rune(c-'a'+10)
+        case base == 16 && 'A' <= c && c <= 'F':
+            d = rune(c-'A'+10)
+        }
+        if d < 0 {
+            break
+        }
+        if r <= unicode.MaxRune {
+            r = r*base+d
+        }
+        digits++
+    }
+    if digits == 0 {
+        return '&'
+    }
+    return r
+}
@@ -0,0 +1,188 @@
+package truncatehtml
+
+import (
+  "html"
+  "math"
+  "strings"
+  "testing"
+)
+
+// TestWouldTruncate checks WouldTruncate around the point where the visible
+// length equals the limit.
+func TestWouldTruncate(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      want bool
+  }{
+    {
+      "",
+      0,
+      false,
+    },
+    {
+      "<p></p>",
+      0,
+      false,
+    },
+    {
+      "<p>a</p>",
+      0,
+      true,
+    },
+    {
+      "<b>12345</b>",
+      5,
+      false,
+    },
+    {
+      "<b>12345</b>",
+      4,
+      true,
+    },
+    {
+      "<b>12 &amp; 45</b><!-- 6789 -->",
+      5,
+      false,
+    },
+    {
+      "<b>12 &amp; 45</b>6",
+      5,
+      true,
+    },
+    {
+      "<b>123456</i>",
+      5,
+      true,
+    },
+  }
+
+  for _, c := range cases {
+    got, err := WouldTruncate([]byte(c.in), c.limit)
+    if err != nil {
+      t.Errorf("Got error calling WouldTruncate(%q, %d). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("WouldTruncate(%q, %d) == %v, want %v", c.in, c.limit, got, c.want)
+    }
+  }
+}
+
+// TestVisibleLengthUpTo checks the capped count and that scanning stops once
+// the limit is reached.
+func TestVisibleLengthUpTo(t *testing.T) {
+  long := "<p>" + strings.Repeat("Lorem ipsum dolor sit amet. ", 1000) + "</p>"
+  cases := []struct {
+      in string
+      limit int
+      want int
+      wantReached bool
+  }{
+    {
+      "",
+      5,
+      0,
+      false,
+    },
+    {
+      "<p>Hello</p>",
+      0,
+      0,
+      true,
+    },
+    {
+      "<p>Hello <b>world</b></p>",
+      5,
+      5,
+      true,
+    },
+    {
+      "<p>Hello <b>world</b></p>",
+      10,
+      10,
+      true,
+    },
+    {
+      "<p>Hello <b>world</b></p>",
+      11,
+      10,
+      false,
+    },
+    {
+      "<p>&amp;&lt;<!-- not counted --> x</p>",
+      100,
+      3,
+      false,
+    },
+    {
+      long,
+      10,
+      10,
+      true,
+    },
+  }
+
+  for _, c := range cases {
+    got, reached := VisibleLengthUpTo([]byte(c.in), c.limit)
+    if got != c.want || reached != c.wantReached {
+      t.Errorf("VisibleLengthUpTo(%q, %d) == %d, %v, want %d, %v", c.in, c.limit, got, reached, c.want, c.wantReached)
+    }
+  }
+
+  // Only the start of the long document is scanned.
+  if _, _, scanned := visibleLengthUpTo([]byte(long), 10); scanned != len("<p>Lorem ipsum") {
+    t.Errorf("visibleLengthUpTo(long, 10) scanned %d bytes, want %d", scanned, len("<p>Lorem ipsum"))
+  }
+}
+
+// TestVisibleLength checks that VisibleLength counts exactly like the
+// truncation scanner and that it doesn't allocate.
+func TestVisibleLength(t *testing.T) {
+  inputs := []string{
+    "",
+    "Plain text",
+    "<p>Hello <b>world</b> &amp; &copy; &bogus; more</p>",
+    "<div class=\"a>b\"><!-- c --><p>x</p><br/>y</div><?php z ?>",
+    "<p>a&zwj;b&#8205;c&#x200d;d&#X200B;e&NoBreak;f&ZeroWidthSpace;g&#8205x;h</p>",
+    "<p>a‍b c\td\n日本語 &#0; &#x110000; &#99999999999; &#x;</p>",
+    "<p>a < b && c > d &; &#; &#x41;</p>",
+    "<o:p>Word</o:p><my-el>Custom</my-el></ b>",
+    "<p>caf\xe9</p>",
+  }
+
+  for _, in := range inputs {
+    tr := newTruncator([]byte(in), math.MaxInt, Options{})
+    tr.discard = true
+    for tr.pos < len(in) {
+      if err := tr.step(); err != nil {
+        t.Fatalf("Got error scanning %q. Error: %s", in, err.Error())
+      }
+    }
+    if got := VisibleLength([]byte(in)); got != tr.visible {
+      t.Errorf("VisibleLength(%q) == %d, want %d", in, got, tr.visible)
+    }
+  }
+
+  for name, want := range zeroWidthEntities {
+    if r := []rune(html.UnescapeString(name)); len(r) != 1 || r[0] != want || !zeroWidth[want] {
+      t.Errorf("zeroWidthEntities has %s, which decodes to %q", name, string(r))
+    }
+  }
+
+  in := []byte(strings.Repeat("<p>Hello <b>world</b> &amp; &zwj; more</p>", 100))
+  if allocs := testing.AllocsPerRun(10, func() { VisibleLength(in) }); allocs != 0 {
+    t.Errorf("VisibleLength allocated %v times, want 0", allocs)
+  }
+}
+
+var measureHtml = []byte(strings.Repeat("<p>The quick <b>brown</b> fox &amp; the lazy dog.</p>\n", 1000))
+
+// BenchmarkVisibleLength measures VisibleLength on a large input. It should
+// report no allocations.
+func BenchmarkVisibleLength(b *testing.B) {
+  b.ReportAllocs()
+  b.SetBytes(int64(len(measureHtml)))
+  for i := 0; i < b.N; i++ {
+    VisibleLength(measureHtml)
+  }
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2015 Matt Borgerson
+// 
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// 
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+// 
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+
+package truncatehtml
+
+import (
+    "html"
+)
+
+// TruncateHtmlWithMoreLink truncates buf like TruncateHtml and, if any content
+// was dropped, follows the ellipsis with a link to href reading linkText:
+//
+//     <p>Some text… <a href="/post/1">Read more</a></p>
+//
+// The link is placed inside the elements that were open at the cut, except
+// that an open <a> is closed first because links cannot be nested. Neither
+// the ellipsis nor the link count toward maxlen. If the whole input fits, it
+// is returned unchanged and no link is added.
+func TruncateHtmlWithMoreLink(buf []byte, maxlen int, ellipsis, href, linkText string) ([]byte, error) {
+    result, err := truncate(buf, maxlen, "", Options{EllipsisOnlyWhenTruncated: true})
+    if err != nil {
+        return nil, err
+    }
+    if !result.truncated {
+        return result.output, nil
+    }
+
+    // Find the outermost open link, if any. It and everything inside it is
+    // closed before the new link.
+    inner := len(result.open)
+    for i, tag := range result.open {
+        if tag.name == "a" {
+            inner = i
+            break
+        }
+    }
+
+    output := make([]byte, 0, len(result.output)+len(ellipsis)+len(href)+len(linkText)+16)
+    output = append(output, result.output[:result.content]...)
+    output = append(output, html.EscapeString(ellipsis)...)
+    output = appendClosers(output, result.open[inner:])
+    output = append(output, ` <a href="`...)
+    output = append(output, html.EscapeString(href)...)
+    output = append(output, `">`...)
+    output = append(output, html.EscapeString(linkText)...)
+    output = append(output, `</a>`...)
+    output = appendClosers(output, result.open[:inner])
+    return output, nil
+}
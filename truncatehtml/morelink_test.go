@@ -0,0 +1,62 @@
+package truncatehtml
+
+import "testing"
+
+// TestTruncateHtmlWithMoreLink checks where the "more" link is placed and that
+// it does not count toward the limit.
+func TestTruncateHtmlWithMoreLink(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      href string
+      want string
+  }{
+    {
+      "<p>Some text that goes on</p>",
+      8,
+      "/post/1",
+      "<p>Some text… <a href=\"/post/1\">Read more</a></p>",
+    },
+    {
+      "<div><p>Some <b>bold text</b></p></div>",
+      6,
+      "/post/1",
+      "<div><p>Some <b>bo… <a href=\"/post/1\">Read more</a></b></p></div>",
+    },
+    {
+      "<p>See <a href=\"/other\">the other post</a> too</p>",
+      6,
+      "/post/1",
+      "<p>See <a href=\"/other\">the…</a> <a href=\"/post/1\">Read more</a></p>",
+    },
+    {
+      "<p>See <a href=\"/other\"><i>the other</i> post</a></p>",
+      6,
+      "/post/1",
+      "<p>See <a href=\"/other\"><i>the…</i></a> <a href=\"/post/1\">Read more</a></p>",
+    },
+    {
+      "<p>Some text</p>",
+      8,
+      "/post?a=1&b=\"2\"",
+      "<p>Some text</p>",
+    },
+    {
+      "<p>Some text that goes on</p>",
+      8,
+      "/post?a=1&b=\"2\"",
+      "<p>Some text… <a href=\"/post?a=1&amp;b=&#34;2&#34;\">Read more</a></p>",
+    },
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtmlWithMoreLink([]byte(c.in), c.limit, "…", c.href, "Read more")
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithMoreLink(%q, %d, \"…\", %q, \"Read more\"). Error: %s", c.in, c.limit, c.href, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithMoreLink(%q, %d, \"…\", %q, \"Read more\") == %q, want %q", c.in, c.limit, c.href, got, c.want)
+    }
+  }
+}
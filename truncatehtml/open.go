@@ -0,0 +1,39 @@
+// Copyright (c) 2015 Matt Borgerson
+// 
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// 
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+// 
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+
+package truncatehtml
+
+// TruncateHtmlOpen truncates buf like TruncateHtml, but leaves the elements
+// that were open at the cut unclosed and appends no ellipsis. The names of
+// those elements are returned in openTags, outermost first, so that the
+// caller can close them later, for example after streaming more content.
+func TruncateHtmlOpen(buf []byte, maxlen int) (content []byte, openTags []string, err error) {
+    result, err := truncate(buf, maxlen, "", Options{})
+    if err != nil {
+        return nil, nil, err
+    }
+
+    openTags = make([]string, len(result.open))
+    for i, tag := range result.open {
+        openTags[i] = tag.name
+    }
+    return result.output[:result.content], openTags, nil
+}
@@ -0,0 +1,75 @@
+package truncatehtml
+
+import (
+  "strings"
+  "testing"
+)
+
+// TestTruncateHtmlOpen checks that no closing tags are added and that the
+// open elements are reported.
+func TestTruncateHtmlOpen(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      want string
+      wantOpen []string
+  }{
+    {
+      "<div><p>Hello <b>world</b></p></div>",
+      7,
+      "<div><p>Hello <b>wo",
+      []string{"div", "p", "b"},
+    },
+    {
+      "<div><p>Hello</p><p>world</p></div>",
+      5,
+      "<div><p>Hello",
+      []string{"div", "p"},
+    },
+    {
+      "<p>Hi<br>there</p>",
+      100,
+      "<p>Hi<br>there</p>",
+      []string{},
+    },
+    {
+      "Plain text",
+      5,
+      "Plain",
+      []string{},
+    },
+    {
+      "",
+      5,
+      "",
+      []string{},
+    },
+  }
+
+  for _, c := range cases {
+    content, open, err := TruncateHtmlOpen([]byte(c.in), c.limit)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlOpen(%q, %d). Error: %s", c.in, c.limit, err.Error())
+    }
+    if string(content) != c.want {
+      t.Errorf("TruncateHtmlOpen(%q, %d) content == %q, want %q", c.in, c.limit, content, c.want)
+    }
+    if strings.Join(open, ",") != strings.Join(c.wantOpen, ",") {
+      t.Errorf("TruncateHtmlOpen(%q, %d) openTags == %q, want %q", c.in, c.limit, open, c.wantOpen)
+    }
+
+    // Closing the open elements gives the same result as TruncateHtml.
+    closed := string(content)
+    for i := len(open)-1; i >= 0; i-- {
+      closed += "</" + open[i] + ">"
+    }
+    want, _ := TruncateHtml([]byte(c.in), c.limit, "")
+    if closed != string(want) {
+      t.Errorf("TruncateHtmlOpen(%q, %d) closed == %q, want %q", c.in, c.limit, closed, want)
+    }
+  }
+
+  if _, _, err := TruncateHtmlOpen([]byte("<p>Bad</b>"), 10); err != UnbalancedTagsError {
+    t.Errorf("TruncateHtmlOpen with unbalanced tags returned error %v, want %v", err, UnbalancedTagsError)
+  }
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2015 Matt Borgerson
+// 
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// 
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+// 
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package truncatehtml
+
+// TruncateHtmlParts truncates buf like TruncateHtml, without an ellipsis, and
+// returns the output in three parts so that callers can add their own markup
+// between them. open holds the start tags at the very beginning of the
+// output of the elements that wrap the cut, outermost first. content holds
+// the rest of the kept input as a single slice, or nothing if nothing else
+// was kept. close holds the closing tags added for the elements open at the
+// cut, innermost first. Joined in order, the parts give the output of
+// TruncateHtml(buf, maxlen, "").
+func TruncateHtmlParts(buf []byte, maxlen int) (open, content, close [][]byte, err error) {
+    result, err := truncate(buf, maxlen, "", Options{})
+    if err != nil {
+        return nil, nil, nil, err
+    }
+
+    // The wrappers are the open elements whose start tags were written one
+    // after another at the start of the output.
+    kept := result.output[:result.content]
+    pos := 0
+    for _, tag := range result.open {
+        if tag.out != pos {
+            break
+        }
+        open = append(open, kept[pos:pos+len(tag.raw)])
+        pos += len(tag.raw)
+    }
+    if pos < len(kept) {
+        content = append(content, kept[pos:])
+    }
+    for i := len(result.open)-1; i >= 0; i-- {
+        close = append(close, appendClosers(nil, result.open[i:i+1]))
+    }
+    return open, content, close, nil
+}
@@ -0,0 +1,99 @@
+package truncatehtml
+
+import (
+  "bytes"
+  "strings"
+  "testing"
+)
+
+// TestTruncateHtmlParts checks the three parts of the output, and that they
+// join to the output of TruncateHtml.
+func TestTruncateHtmlParts(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      open []string
+      content []string
+      close []string
+  }{
+    {
+      "<div class=\"x\"><p>Hello <b>world</b></p></div>",
+      7,
+      []string{"<div class=\"x\">", "<p>"},
+      []string{"Hello <b>wo"},
+      []string{"</b>", "</p>", "</div>"},
+    },
+    {
+      "<div>\n<p>Hello</p><p>world</p></div>",
+      7,
+      []string{"<div>"},
+      []string{"\n<p>Hello</p><p>wo"},
+      []string{"</p>", "</div>"},
+    },
+    {
+      "<p>Hello</p>",
+      100,
+      nil,
+      []string{"<p>Hello</p>"},
+      nil,
+    },
+    {
+      "<p><b>Hello</b></p>",
+      0,
+      nil,
+      nil,
+      nil,
+    },
+    {
+      "Plain text",
+      5,
+      nil,
+      []string{"Plain"},
+      nil,
+    },
+    {
+      "<p>ab</p><p>cdef</p>",
+      3,
+      nil,
+      []string{"<p>ab</p><p>c"},
+      []string{"</p>"},
+    },
+    {
+      "<div><p>ab</p><p>cdef</p></div>",
+      3,
+      []string{"<div>"},
+      []string{"<p>ab</p><p>c"},
+      []string{"</p>", "</div>"},
+    },
+  }
+
+  join := func(parts [][]byte) string {
+    return string(bytes.Join(parts, []byte("|")))
+  }
+  for _, c := range cases {
+    open, content, close, err := TruncateHtmlParts([]byte(c.in), c.limit)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlParts(%q, %d). Error: %s", c.in, c.limit, err.Error())
+    }
+    if join(open) != strings.Join(c.open, "|") || join(content) != strings.Join(c.content, "|") ||
+       join(close) != strings.Join(c.close, "|") {
+      t.Errorf("TruncateHtmlParts(%q, %d) == %q, %q, %q, want %q, %q, %q", c.in, c.limit, open, content, close, c.open, c.content, c.close)
+    }
+
+    // The parts reassemble to the output of TruncateHtml.
+    var all []byte
+    for _, parts := range [][][]byte{open, content, close} {
+      for _, part := range parts {
+        all = append(all, part...)
+      }
+    }
+    want, _ := TruncateHtml([]byte(c.in), c.limit, "")
+    if string(all) != string(want) {
+      t.Errorf("TruncateHtmlParts(%q, %d) joined == %q, want %q", c.in, c.limit, all, want)
+    }
+  }
+
+  if _, _, _, err := TruncateHtmlParts([]byte("<p>Bad</b>"), 10); err != UnbalancedTagsError {
+    t.Errorf("TruncateHtmlParts with unbalanced tags returned error %v, want %v", err, UnbalancedTagsError)
+  }
+}
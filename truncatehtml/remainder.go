@@ -0,0 +1,39 @@
+// Copyright (c) 2015 Matt Borgerson
+// 
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// 
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+// 
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package truncatehtml
+
+// TruncateHtmlWithRemainder truncates buf like TruncateHtml and also returns
+// the plain text of the content that was dropped, extracted as by
+// TruncateText: tags and comments are removed and entities decoded, but
+// whitespace is left as it appeared in buf. remainderText is empty if nothing
+// was dropped. Unlike TruncateHtml, which stops reading at the cut, this
+// returns an error if the dropped part has unbalanced tags.
+func TruncateHtmlWithRemainder(buf []byte, maxlen int, ellipsis string) (out []byte, remainderText []byte, err error) {
+    result, err := truncate(buf, maxlen, ellipsis, Options{})
+    if err != nil {
+        return nil, nil, err
+    }
+    remainderText, err = extractText(buf[result.cut:], result.open, TextOptions{})
+    if err != nil {
+        return nil, nil, err
+    }
+    return result.output, remainderText, nil
+}
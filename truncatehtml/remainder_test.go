@@ -0,0 +1,64 @@
+package truncatehtml
+
+import "testing"
+
+// TestTruncateHtmlWithRemainder checks that the remainder holds the plain
+// text that truncation dropped.
+func TestTruncateHtmlWithRemainder(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      want string
+      wantRemainder string
+  }{
+    {
+      "<p>Hello <b>world</b> &amp; more</p>",
+      7,
+      "<p>Hello <b>wo...</b></p>",
+      "rld & more",
+    },
+    {
+      "<div><p>One</p><!-- note --><p>Two &lt;3</p></div>",
+      3,
+      "<div><p>One...</p></div>",
+      "Two <3",
+    },
+    {
+      "<p>Hello</p>",
+      5,
+      "<p>Hello...</p>",
+      "",
+    },
+    {
+      "<p>Hello</p>",
+      100,
+      "<p>Hello</p>...",
+      "",
+    },
+    {
+      "Plain text",
+      5,
+      "Plain...",
+      " text",
+    },
+  }
+
+  for _, c := range cases {
+    out, remainder, err := TruncateHtmlWithRemainder([]byte(c.in), c.limit, "...")
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithRemainder(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if string(out) != c.want {
+      t.Errorf("TruncateHtmlWithRemainder(%q, %d, \"...\") out == %q, want %q", c.in, c.limit, out, c.want)
+    }
+    if string(remainder) != c.wantRemainder {
+      t.Errorf("TruncateHtmlWithRemainder(%q, %d, \"...\") remainderText == %q, want %q", c.in, c.limit, remainder, c.wantRemainder)
+    }
+  }
+
+  for _, in := range []string{"<p>Bad</b>", "<p>Hello</p></div>"} {
+    if _, _, err := TruncateHtmlWithRemainder([]byte(in), 2, ""); err != UnbalancedTagsError {
+      t.Errorf("TruncateHtmlWithRemainder(%q, 2, \"\") returned error %v, want %v", in, err, UnbalancedTagsError)
+    }
+  }
+}
@@ -0,0 +1,60 @@
+package truncatehtml
+
+import "testing"
+
+// TestTruncateHtmlRemaining checks the number of dropped characters.
+func TestTruncateHtmlRemaining(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      want string
+      wantDropped int
+  }{
+    {
+      "<p>Hello <b>world</b></p>",
+      7,
+      "<p>Hello <b>wo...</b></p>",
+      3,
+    },
+    {
+      "<p>Hello <b>world</b></p><p>&amp; more</p>",
+      5,
+      "<p>Hello...</p>",
+      10,
+    },
+    {
+      "<p>Hello <b>world</b></p>",
+      10,
+      "<p>Hello <b>world...</b></p>",
+      0,
+    },
+    {
+      "<p>Hello</p>",
+      100,
+      "<p>Hello</p>...",
+      0,
+    },
+    {
+      "<p>Hello</p>",
+      0,
+      "",
+      5,
+    },
+    {
+      "",
+      10,
+      "",
+      0,
+    },
+  }
+
+  for _, c := range cases {
+    out, dropped, err := TruncateHtmlRemaining([]byte(c.in), c.limit, "...")
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlRemaining(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if string(out) != c.want || dropped != c.wantDropped {
+      t.Errorf("TruncateHtmlRemaining(%q, %d, \"...\") == %q, %d, want %q, %d", c.in, c.limit, out, dropped, c.want, c.wantDropped)
+    }
+  }
+}
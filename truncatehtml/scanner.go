@@ -0,0 +1,82 @@
+// Copyright (c) 2015 Matt Borgerson
+// 
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// 
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+// 
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package truncatehtml
+
+import (
+    "unicode/utf8"
+)
+
+// Kind identifies what a token returned by Scanner.Next is.
+type Kind int
+
+// The kinds of token, in the same order as tokenKind.
+const (
+    KindText      Kind = iota // A single character of text
+    KindEntity                // A character reference such as &amp;
+    KindStartTag              // <name ...>
+    KindEndTag                // </name>
+    KindComment               // <!-- ... -->
+    KindDirective             // <!DOCTYPE ...>, <?xml ...?> and the like
+)
+
+// Scanner splits HTML into tokens the way TruncateHtml reads it, and reports
+// how many visible characters each token adds, so that other code can count
+// visible text without truncating. Tags are not checked for balance.
+type Scanner struct {
+    buf []byte
+    pos int
+}
+
+// NewScanner returns a Scanner that reads buf.
+func NewScanner(buf []byte) *Scanner {
+    return &Scanner{buf: buf}
+}
+
+// Next returns the next token: its kind, its bytes in buf and the number of
+// visible characters it adds, which is 1 for a text character or entity that
+// TruncateHtml counts and 0 otherwise. A '<' or '&' that doesn't start any
+// markup is returned as text. ok is false once buf has been read. Next
+// doesn't allocate.
+func (s *Scanner) Next() (kind Kind, raw []byte, visibleDelta int, ok bool) {
+    if s.pos >= len(s.buf) {
+        return 0, nil, 0, false
+    }
+    start := s.pos
+    tokKind, end, _, _ := scanToken(s.buf, start)
+    s.pos = end
+    raw = s.buf[start:end]
+
+    // The truncator reads tokens with readToken rather than a Scanner, as it
+    // needs tag names and decoded entities. Both split the input with
+    // scanToken and classify characters with CountMode.countsToken.
+    switch tokKind {
+    case textToken:
+        r, _ := utf8.DecodeRune(raw)
+        if PrintableNonSpace.countsToken(token{kind: textToken, r: r}) {
+            visibleDelta = 1
+        }
+    case entityToken:
+        if PrintableNonSpace.countsToken(token{kind: entityToken, r: entityRune(raw)}) {
+            visibleDelta = 1
+        }
+    }
+    return Kind(tokKind), raw, visibleDelta, true
+}
@@ -0,0 +1,94 @@
+package truncatehtml
+
+import (
+  "fmt"
+  "strings"
+  "testing"
+)
+
+// TestScanner checks the tokens the Scanner returns and the visible
+// characters each one adds.
+func TestScanner(t *testing.T) {
+  cases := []struct {
+      in string
+      want []string
+  }{
+    {
+      "",
+      nil,
+    },
+    {
+      "<p>Hi</p>",
+      []string{"starttag <p> 0", "text H 1", "text i 1", "endtag </p> 0"},
+    },
+    {
+      "a &amp;&zwj;&bogus;<!-- c -->日",
+      []string{"text a 1", "text   0", "entity &amp; 1", "entity &zwj; 0", "entity &bogus; 1", "comment <!-- c --> 0", "text 日 1"},
+    },
+    {
+      "<!DOCTYPE html><br/>< x&",
+      []string{"directive <!DOCTYPE html> 0", "starttag <br/> 0", "text < 1", "text   0", "text x 1", "text & 1"},
+    },
+    {
+      "<a title=\"x>y\">z</b>",
+      []string{"starttag <a title=\"x>y\"> 0", "text z 1", "endtag </b> 0"},
+    },
+  }
+  names := map[Kind]string{
+    KindText: "text", KindEntity: "entity", KindStartTag: "starttag",
+    KindEndTag: "endtag", KindComment: "comment", KindDirective: "directive",
+  }
+
+  for _, c := range cases {
+    var got []string
+    s := NewScanner([]byte(c.in))
+    for {
+      kind, raw, delta, ok := s.Next()
+      if !ok {
+        break
+      }
+      got = append(got, fmt.Sprintf("%s %s %d", names[kind], raw, delta))
+    }
+    if strings.Join(got, "|") != strings.Join(c.want, "|") {
+      t.Errorf("Scanner tokens of %q == %q, want %q", c.in, got, c.want)
+    }
+  }
+
+}
+
+// TestScannerVisible checks the total of the visible characters the Scanner
+// reports.
+func TestScannerVisible(t *testing.T) {
+  cases := []struct {
+      in string
+      want int
+  }{
+    {
+      "<p>Hello <b>world</b> &amp; &zwj; <!-- x --> more</p>",
+      15,
+    },
+    {
+      "<p>a&zwj;b&#8205;c&#x200d;d&#X200B;e&NoBreak;f&ZeroWidthSpace;g&#8205x;h</p>",
+      8,
+    },
+    {
+      "<p>&copy; &bogus; &#x41; &#x; &#0;</p>",
+      5,
+    },
+  }
+
+  for _, c := range cases {
+    total := 0
+    s := NewScanner([]byte(c.in))
+    for {
+      _, _, delta, ok := s.Next()
+      if !ok {
+        break
+      }
+      total += delta
+    }
+    if total != c.want {
+      t.Errorf("Scanner visible total for %q == %d, want %d", c.in, total, c.want)
+    }
+  }
+}
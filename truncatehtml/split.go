@@ -0,0 +1,45 @@
+// Copyright (c) 2015 Matt Borgerson
+// 
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// 
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+// 
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+
+package truncatehtml
+
+// SplitHtml truncates buf exactly like TruncateHtml and also returns the rest
+// of the input as tail. Elements that were open at the cut are closed in head
+// and re-opened at the start of tail using their original start tags, so
+// attributes survive the split and both halves are valid HTML on their own.
+// If nothing visible remains after the cut, tail is empty.
+func SplitHtml(buf []byte, maxlen int, ellipsis string) (head, tail []byte, err error) {
+    result, err := truncate(buf, maxlen, ellipsis, Options{})
+    if err != nil {
+        return nil, nil, err
+    }
+
+    rest := buf[result.cut:]
+    if !hasVisible(rest, PrintableNonSpace) {
+        return result.output, []byte{}, nil
+    }
+
+    for _, tag := range result.open {
+        tail = append(tail, tag.raw...)
+    }
+    tail = append(tail, rest...)
+    return result.output, tail, nil
+}
@@ -0,0 +1,81 @@
+package truncatehtml
+
+import "testing"
+
+// TestSplitHtml checks that the tail re-opens the elements that were open at
+// the cut with their original attributes.
+func TestSplitHtml(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      head string
+      tail string
+  }{
+    {
+      "<p>Some <em>italic text</em> here</p>",
+      7,
+      "<p>Some <em>ita</em></p>",
+      "<p><em>lic text</em> here</p>",
+    },
+    {
+      "<p class=\"intro\">Some <em class=\"note\" data-id='7'>italic text</em> here</p>",
+      7,
+      "<p class=\"intro\">Some <em class=\"note\" data-id='7'>ita</em></p>",
+      "<p class=\"intro\"><em class=\"note\" data-id='7'>lic text</em> here</p>",
+    },
+    {
+      "<p>Some <em>italic</em> text</p>",
+      10,
+      "<p>Some <em>italic</em></p>",
+      "<p><em></em> text</p>",
+    },
+    {
+      "<p>Some <em>italic</em></p>",
+      10,
+      "<p>Some <em>italic</em></p>",
+      "",
+    },
+    {
+      "<p>Short</p>",
+      100,
+      "<p>Short</p>",
+      "",
+    },
+  }
+
+  for _, c := range cases {
+    head, tail, err := SplitHtml([]byte(c.in), c.limit, "")
+    if err != nil {
+      t.Errorf("Got error calling SplitHtml(%q, %d, \"\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if string(head) != c.head || string(tail) != c.tail {
+      t.Errorf("SplitHtml(%q, %d, \"\") == %q, %q, want %q, %q", c.in, c.limit, head, tail, c.head, c.tail)
+    }
+  }
+}
+
+// TestSplitHtmlLinks checks that links re-opened in tail keep their href byte
+// for byte, including fragments, query strings, entities and percent-encoding.
+func TestSplitHtmlLinks(t *testing.T) {
+  hrefs := []string{
+    "https://example.com/page#frag?q=1",
+    "https://example.com/search?q=go+html&amp;page=2#results",
+    "https://example.com/search?q=a&b=c",
+    "/docs/caf%C3%A9%20menu?x=%3Cb%3E#top",
+    "https://example.com/?q=\"x\">y",
+  }
+
+  for _, href := range hrefs {
+    start := "<a href='" + href + "' title=\"T\">"
+    in := "<p>" + start + "Click here</a> now</p>"
+    head, tail, err := SplitHtml([]byte(in), 3, "")
+    if err != nil {
+      t.Errorf("Got error calling SplitHtml(%q, 3, \"\"). Error: %s", in, err.Error())
+    }
+    wantHead := "<p>" + start + "Cli</a></p>"
+    wantTail := "<p>" + start + "ck here</a> now</p>"
+    if string(head) != wantHead || string(tail) != wantTail {
+      t.Errorf("SplitHtml(%q, 3, \"\") == %q, %q, want %q, %q", in, head, tail, wantHead, wantTail)
+    }
+  }
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2015 Matt Borgerson
+// 
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// 
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+// 
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+
+package truncatehtml
+
+import (
+    "html"
+    "unicode"
+)
+
+// SummarizeHtml truncates buf like TruncateHtml and flattens the result into
+// a single line of plain text, for previews such as notifications. The ends
+// of block-level elements and <br> become a space, other tags and comments
+// are removed, entities are decoded and runs of whitespace are collapsed to a
+// single space. The ellipsis is appended as is, and only when content was
+// dropped.
+func SummarizeHtml(buf []byte, maxlen int, ellipsis string) ([]byte, error) {
+    result, err := truncate(buf, maxlen, "", Options{EllipsisOnlyWhenTruncated: true})
+    if err != nil {
+        return nil, err
+    }
+
+    var output []byte
+    space := false
+    for pos := 0; pos < len(result.output); {
+        tok := readToken(result.output, pos)
+        pos = tok.end
+
+        switch tok.kind {
+        case textToken, entityToken:
+            if unicode.IsSpace(tok.r) {
+                space = true
+                continue
+            }
+            if space && len(output) > 0 {
+                output = append(output, ' ')
+            }
+            space = false
+            if tok.kind == entityToken {
+                output = append(output, html.UnescapeString(string(result.output[tok.start:tok.end]))...)
+            } else {
+                output = append(output, result.output[tok.start:tok.end]...)
+            }
+
+        case startTagToken, endTagToken:
+            if tok.name == "br" || blockElements[tok.name] {
+                space = true
+            }
+        }
+    }
+
+    if result.truncated {
+        output = append(output, ellipsis...)
+    }
+    return output, nil
+}
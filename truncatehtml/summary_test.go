@@ -0,0 +1,68 @@
+package truncatehtml
+
+import "testing"
+
+// TestSummarizeHtml checks that the output is a single line of plain text.
+func TestSummarizeHtml(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      want string
+  }{
+    {
+      "<p>First paragraph.</p><p>Second paragraph.</p>",
+      100,
+      "First paragraph. Second paragraph.",
+    },
+    {
+      "<p>First paragraph.</p>\n\n<p>Second paragraph.</p>",
+      21,
+      "First paragraph. Second...",
+    },
+    {
+      "<div>Line one<br>Line two<br/>Line three</div>",
+      100,
+      "Line one Line two Line three",
+    },
+    {
+      "<ul>\n  <li>Apples</li>\n  <li>Pears</li>\n  <li>Plums</li>\n</ul>",
+      11,
+      "Apples Pears...",
+    },
+    {
+      "<p>Some <b>bold</b>er <a href=\"/x\">text</a> &amp; more</p>",
+      100,
+      "Some bolder text & more",
+    },
+    {
+      "<p>Fish &lt;&gt; chips</p><!-- note --><p>Peas</p>",
+      11,
+      "Fish <> chips...",
+    },
+    {
+      "  <p>  spaced\n\tout  </p>  ",
+      100,
+      "spaced out",
+    },
+    {
+      "",
+      10,
+      "",
+    },
+  }
+
+  for _, c := range cases {
+    out, err := SummarizeHtml([]byte(c.in), c.limit, "...")
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling SummarizeHtml(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("SummarizeHtml(%q, %d, \"...\") == %q, want %q", c.in, c.limit, got, c.want)
+    }
+  }
+
+  if _, err := SummarizeHtml([]byte("<p>Bad</b>"), 10, "..."); err != UnbalancedTagsError {
+    t.Errorf("SummarizeHtml with unbalanced tags returned error %v, want %v", err, UnbalancedTagsError)
+  }
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2015 Matt Borgerson
+// 
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// 
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+// 
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+
+package truncatehtml
+
+import (
+    "html"
+    "math"
+)
+
+// TruncateHtmlTableRows truncates buf after maxRows table body rows, that is
+// <tr> elements in a <tbody>, or directly in a <table>. Header rows in a
+// <thead>, and rows of tables nested inside a cell, are kept and don't count.
+// If rows were dropped, the table is closed and ellipsis is placed right after
+// it; otherwise buf is returned unchanged. Text is not counted, so everything
+// up to the cut is kept.
+func TruncateHtmlTableRows(buf []byte, maxRows int, ellipsis string) ([]byte, error) {
+    t := newTruncator(buf, math.MaxInt, Options{})
+    rows := 0
+    for t.pos < len(buf) {
+        tok := readToken(buf, t.pos)
+        if tok.kind == startTagToken && tok.name == "tr" && len(t.stack) > 0 && tableDepth(t.stack) == 1 {
+            if parent := rowParent(t.stack); parent == "tbody" || parent == "table" {
+                if rows >= maxRows {
+                    break
+                }
+                rows++
+            }
+        }
+        if err := t.step(); err != nil {
+            return nil, err
+        }
+    }
+    if t.pos == len(buf) {
+        return t.out, nil
+    }
+
+    // Close the table first, so that the ellipsis follows it rather than
+    // landing inside it.
+    table := len(t.stack)-1
+    for t.stack[table].name != "table" {
+        table--
+    }
+    output := appendClosers(t.out, t.stack[table:])
+    output = append(output, html.EscapeString(ellipsis)...)
+    return appendClosers(output, t.stack[:table]), nil
+}
+
+// rowParent returns the name of the element that a <tr> starting now would be
+// a child of, once the rows and cells left open before it are closed by the
+// implied end tags.
+func rowParent(stack []openTag) string {
+    closes := impliedEnds["tr"]
+    i := len(stack)-1
+    for i > 0 && closes[stack[i].name] {
+        i--
+    }
+    return stack[i].name
+}
+
+// tableDepth returns the number of tables that are open in stack.
+func tableDepth(stack []openTag) int {
+    depth := 0
+    for _, tag := range stack {
+        if tag.name == "table" {
+            depth++
+        }
+    }
+    return depth
+}
@@ -0,0 +1,115 @@
+package truncatehtml
+
+import "testing"
+
+// TestTruncateHtmlTableRows checks that body rows are cut after the limit
+// while the header is kept.
+func TestTruncateHtmlTableRows(t *testing.T) {
+  table := "<table><thead><tr><th>Name</th><th>Score</th></tr></thead>" +
+    "<tbody><tr><td>Ann</td><td>9</td></tr><tr><td>Bob</td><td>7</td></tr>" +
+    "<tr><td>Cy</td><td>5</td></tr></tbody></table>"
+  cases := []struct {
+      in string
+      rows int
+      want string
+  }{
+    {
+      table,
+      2,
+      "<table><thead><tr><th>Name</th><th>Score</th></tr></thead>" +
+        "<tbody><tr><td>Ann</td><td>9</td></tr><tr><td>Bob</td><td>7</td></tr>" +
+        "</tbody></table>...",
+    },
+    {
+      table,
+      0,
+      "<table><thead><tr><th>Name</th><th>Score</th></tr></thead><tbody></tbody></table>...",
+    },
+    {
+      table,
+      3,
+      table,
+    },
+    {
+      "<div><h2>Results</h2>" + table + "<p>Footer</p></div>",
+      1,
+      "<div><h2>Results</h2><table><thead><tr><th>Name</th><th>Score</th></tr></thead>" +
+        "<tbody><tr><td>Ann</td><td>9</td></tr></tbody></table>...</div>",
+    },
+    {
+      "<table><tr><td>1</td></tr><tr><td>2</td></tr></table>",
+      1,
+      "<table><tr><td>1</td></tr></table>...",
+    },
+    {
+      "<table><tbody><tr><td><table><tr><td>inner</td></tr></table></td></tr><tr><td>2</td></tr></tbody></table>",
+      1,
+      "<table><tbody><tr><td><table><tr><td>inner</td></tr></table></td></tr></tbody></table>...",
+    },
+    {
+      "<table><tbody><tr><td>1</td><tr><td>2</td><tr><td>3</td></tbody></table>",
+      1,
+      "<table><tbody><tr><td>1</td></tr></tbody></table>...",
+    },
+    {
+      "<table><tr><td>1<tr><td>2<tr><td>3</table>",
+      2,
+      "<table><tr><td>1<tr><td>2</td></tr></table>...",
+    },
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtmlTableRows([]byte(c.in), c.rows, "...")
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlTableRows(%q, %d, \"...\"). Error: %s", c.in, c.rows, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlTableRows(%q, %d, \"...\") == %q, want %q", c.in, c.rows, got, c.want)
+    }
+  }
+}
+
+// TestTruncateHtmlTableRowsCaption checks that the caption and column groups
+// before the rows are kept when rows are dropped.
+func TestTruncateHtmlTableRowsCaption(t *testing.T) {
+  head := "<table><caption>Scores <b>2015</b></caption>" +
+    "<colgroup><col span=\"1\" class=\"name\"><col class=\"score\"></colgroup>"
+  cases := []struct {
+    in   string
+    rows int
+    want string
+  }{
+    {
+      head + "<tbody><tr><td>Ann</td></tr><tr><td>Bob</td></tr><tr><td>Cy</td></tr></tbody></table>",
+      2,
+      head + "<tbody><tr><td>Ann</td></tr><tr><td>Bob</td></tr></tbody></table>...",
+    },
+    {
+      head + "<tr><td>Ann</td></tr><tr><td>Bob</td></tr><tr><td>Cy</td></tr></table>",
+      1,
+      head + "<tr><td>Ann</td></tr></table>...",
+    },
+    {
+      head + "<tbody><tr><td>Ann</td></tr><tr><td>Bob</td></tr></tbody></table>",
+      0,
+      head + "<tbody></tbody></table>...",
+    },
+    {
+      "<table><caption>Empty</caption><col><tr><td>1</td></tr><tr><td>2</td></tr></table>",
+      1,
+      "<table><caption>Empty</caption><col><tr><td>1</td></tr></table>...",
+    },
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtmlTableRows([]byte(c.in), c.rows, "...")
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlTableRows(%q, %d, \"...\"). Error: %s", c.in, c.rows, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlTableRows(%q, %d, \"...\") == %q, want %q", c.in, c.rows, got, c.want)
+    }
+  }
+}
@@ -0,0 +1,176 @@
+// Copyright (c) 2015 Matt Borgerson
+// 
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// 
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+// 
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+
+package truncatehtml
+
+import (
+    "bytes"
+    "html"
+    "math"
+    "unicode"
+    "unicode/utf8"
+)
+
+// TextOptions controls the behavior of TruncateText. The zero value extracts
+// the text alone.
+type TextOptions struct {
+    // IncludeLinkURLsInText follows the text of each link with its URL in
+    // parentheses, as in "the docs (https://example.com/docs)". The URL
+    // counts toward maxlen like the rest of the text. It is left out when
+    // the link text is the URL itself.
+    IncludeLinkURLsInText bool
+
+    // BlockSeparator, if set, is written between the text of block-level
+    // elements, such as paragraphs and list items, and in place of <br>.
+    // Whitespace next to the separator is dropped, and a separator is never
+    // written at the start or end of the text or twice in a row. For
+    // example, "\n" puts each paragraph on its own line.
+    BlockSeparator string
+
+    // CountEllipsis makes the visible characters of the ellipsis count
+    // toward maxlen, so that the text and the ellipsis together are never
+    // longer than maxlen. Text that fits within maxlen is returned whole. An
+    // ellipsis longer than maxlen by itself is shortened to fit, and no text
+    // is kept.
+    CountEllipsis bool
+}
+
+// TruncateText extracts the plain text of buf, with tags and comments removed
+// and entities decoded, and truncates it to maxlen visible characters,
+// counted as TruncateHtml counts them. Whitespace is kept as it appeared in
+// buf. The ellipsis, which may be any suffix such as " [truncated]", is
+// appended as is, and only when text was dropped. An error is returned if buf
+// has unbalanced tags.
+func TruncateText(buf []byte, maxlen int, ellipsis string, opts TextOptions) ([]byte, error) {
+    text, err := extractText(buf, nil, opts)
+    if err != nil {
+        return nil, err
+    }
+
+    cut := textCut(text, maxlen)
+    if !textTruncated(text[cut:]) {
+        return text[:cut], nil
+    }
+    if opts.CountEllipsis {
+        room := maxlen
+        for _, r := range ellipsis {
+            if PrintableNonSpace.counts(r) {
+                room--
+            }
+        }
+        if room < 0 {
+            // The ellipsis alone is too long, so no text is kept and the
+            // ellipsis is shortened from its end to fit.
+            ellipsis = ellipsis[:textCut([]byte(ellipsis), maxlen)]
+            room = 0
+        }
+        cut = textCut(text, room)
+    }
+    return append(text[:cut], ellipsis...), nil
+}
+
+// textCut returns the length of the longest prefix of text with at most
+// maxlen visible characters.
+func textCut(text []byte, maxlen int) int {
+    cut := 0
+    for visible := 0; cut < len(text) && visible < maxlen; {
+        r, size := utf8.DecodeRune(text[cut:])
+        if PrintableNonSpace.counts(r) {
+            visible++
+        }
+        cut += size
+    }
+    return cut
+}
+
+// textTruncated reports whether rest, the text after the cut, has anything
+// visible. Trailing whitespace alone does not make the text truncated.
+func textTruncated(rest []byte) bool {
+    for _, r := range string(rest) {
+        if PrintableNonSpace.counts(r) {
+            return true
+        }
+    }
+    return false
+}
+
+// extractText returns the plain text of buf as described for TruncateText.
+// open lists the elements that are already open where buf starts, outermost
+// first, so that their end tags in buf are matched.
+func extractText(buf []byte, open []openTag, opts TextOptions) ([]byte, error) {
+    t := newTruncator(buf, math.MaxInt, Options{})
+    t.discard = true
+    t.stack = append(t.stack, open...)
+    text := []byte{}
+    link := 0
+    separate := false
+    for t.pos < len(buf) {
+        tok := readToken(buf, t.pos)
+        skip := false
+        if opts.BlockSeparator != "" {
+            switch {
+            case tok.kind == startTagToken || tok.kind == endTagToken:
+                if tok.name == "br" || blockElements[tok.name] {
+                    text = bytes.TrimRightFunc(text, unicode.IsSpace)
+                    separate = len(text) > 0
+                }
+            case tok.kind == textToken && unicode.IsSpace(tok.r) && (separate || len(text) == 0):
+                skip = true
+            case (tok.kind == textToken || tok.kind == entityToken) && separate:
+                text = append(text, opts.BlockSeparator...)
+                separate = false
+            }
+        }
+
+        switch {
+        case skip:
+        case tok.kind == textToken:
+            text = append(text, buf[tok.start:tok.end]...)
+        case tok.kind == entityToken:
+            text = append(text, html.UnescapeString(string(buf[tok.start:tok.end]))...)
+        case tok.kind == startTagToken && tok.name == "a":
+            link = len(text)
+        case tok.kind == endTagToken && tok.name == "a" && opts.IncludeLinkURLsInText:
+            if n := len(t.stack); n > 0 && t.stack[n-1].name == "a" {
+                if href := attributeValue(t.stack[n-1].raw, "href"); href != "" && href != string(text[link:]) {
+                    text = append(text, " ("...)
+                    text = append(text, href...)
+                    text = append(text, ')')
+                }
+            }
+        }
+        if err := t.step(); err != nil {
+            return nil, err
+        }
+    }
+    return text, nil
+}
+
+// attributeValue returns the value of the attribute with the given lowercase
+// name in the start tag raw, or "" if there is none.
+func attributeValue(raw []byte, name string) string {
+    for _, attr := range tagAttributes(raw) {
+        if attr.name == name {
+            return attr.value
+        }
+    }
+    return ""
+}
@@ -0,0 +1,176 @@
+package truncatehtml
+
+import "testing"
+
+// TestTruncateText checks plain text extraction with and without link URLs.
+func TestTruncateText(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      opts TextOptions
+      want string
+  }{
+    {
+      "<p>Read <a href=\"https://example.com/docs\">the docs</a> first.</p>",
+      100,
+      TextOptions{},
+      "Read the docs first.",
+    },
+    {
+      "<p>Read <a href=\"https://example.com/docs\">the docs</a> first.</p>",
+      100,
+      TextOptions{IncludeLinkURLsInText: true},
+      "Read the docs (https://example.com/docs) first.",
+    },
+    {
+      "<p>Read <a href=\"https://example.com/docs\">the docs</a> first.</p>",
+      15,
+      TextOptions{},
+      "Read the docs firs...",
+    },
+    {
+      "<p>Read <a href=\"https://example.com/docs\">the docs</a> first.</p>",
+      15,
+      TextOptions{IncludeLinkURLsInText: true},
+      "Read the docs (htt...",
+    },
+    {
+      "<p>See <a href=\"https://example.com\">https://example.com</a></p>",
+      100,
+      TextOptions{IncludeLinkURLsInText: true},
+      "See https://example.com",
+    },
+    {
+      "<p>Fish &amp; <a href=\"/menu?a=1&amp;b=2\">chips</a></p>",
+      100,
+      TextOptions{IncludeLinkURLsInText: true},
+      "Fish & chips (/menu?a=1&b=2)",
+    },
+    {
+      "<p><a name=\"top\">Anchor</a> text</p>",
+      100,
+      TextOptions{IncludeLinkURLsInText: true},
+      "Anchor text",
+    },
+    {
+      "<p>Trailing space </p>\n",
+      14,
+      TextOptions{},
+      "Trailing space \n",
+    },
+  }
+
+  for _, c := range cases {
+    out, err := TruncateText([]byte(c.in), c.limit, "...", c.opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateText(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateText(%q, %d, \"...\") with %+v == %q, want %q", c.in, c.limit, c.opts, got, c.want)
+    }
+  }
+
+  if _, err := TruncateText([]byte("<p>Bad</b>"), 10, "...", TextOptions{}); err != UnbalancedTagsError {
+    t.Errorf("TruncateText with unbalanced tags returned error %v, want %v", err, UnbalancedTagsError)
+  }
+}
+
+// TestBlockSeparator checks that block-level elements are separated in the
+// extracted text.
+func TestBlockSeparator(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      sep string
+      want string
+  }{
+    {
+      "<p>First paragraph.</p>\n<p>Second paragraph.</p>",
+      100,
+      "",
+      "First paragraph.\nSecond paragraph.",
+    },
+    {
+      "<p>First paragraph.</p>\n<p>Second paragraph.</p>",
+      100,
+      "\n",
+      "First paragraph.\nSecond paragraph.",
+    },
+    {
+      "<div><p>One</p><p>Two</p></div><p>Three</p>",
+      100,
+      "\n\n",
+      "One\n\nTwo\n\nThree",
+    },
+    {
+      "<ul>\n  <li>Apples </li>\n  <li>Pears</li>\n</ul>\n",
+      100,
+      "\n",
+      "Apples\nPears",
+    },
+    {
+      "<p>Line one<br>Line two</p><p><b>Bold</b> start</p>",
+      100,
+      " | ",
+      "Line one | Line two | Bold start",
+    },
+    {
+      "<p>First paragraph.</p>\n<p>Second paragraph.</p>",
+      20,
+      "\n",
+      "First paragraph.\nSecon...",
+    },
+    {
+      "<p>A</p><p></p><p>B</p><p>&amp;C</p>",
+      100,
+      "\n",
+      "A\nB\n&C",
+    },
+  }
+
+  for _, c := range cases {
+    opts := TextOptions{BlockSeparator: c.sep}
+    out, err := TruncateText([]byte(c.in), c.limit, "...", opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateText(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateText(%q, %d, \"...\") with BlockSeparator=%q == %q, want %q", c.in, c.limit, c.sep, got, c.want)
+    }
+  }
+}
+
+// TestTruncateTextSuffix checks that a suffix is appended only when text was
+// dropped, and that with CountEllipsis it counts toward maxlen.
+func TestTruncateTextSuffix(t *testing.T) {
+  cases := []struct {
+    in     string
+    limit  int
+    counts bool
+    want   string
+  }{
+    {"<p>Short text</p>", 100, false, "Short text"},
+    {"<p>Short text</p>", 9, false, "Short text"},
+    {"<p>Short text</p>  ", 9, true, "Short text"},
+    {"<p>A longer <b>piece</b> of text</p>", 10, false, "A longer pie [truncated]"},
+    {"<p>A longer <b>piece</b> of text</p>", 18, true, "A longer piece of text"},
+    {"<p>A longer <b>piece</b> of text</p>", 17, true, "A longe [truncated]"},
+    {"<p>A longer <b>piece</b> of text</p>", 16, true, "A long [truncated]"},
+    {"<p>A longer <b>piece</b> of text</p>", 11, true, " [truncated]"},
+    {"<p>A longer <b>piece</b> of text</p>", 5, true, " [trun"},
+    {"<p>A longer <b>piece</b> of text</p>", 0, true, ""},
+  }
+
+  for _, c := range cases {
+    out, err := TruncateText([]byte(c.in), c.limit, " [truncated]", TextOptions{CountEllipsis: c.counts})
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateText(%q, %d, \" [truncated]\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateText(%q, %d, \" [truncated]\") with CountEllipsis=%t == %q, want %q", c.in, c.limit, c.counts, got, c.want)
+    }
+  }
+}
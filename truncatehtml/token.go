@@ -0,0 +1,291 @@
+// Copyright (c) 2015 Matt Borgerson
+// 
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// 
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+// 
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+
+package truncatehtml
+
+import (
+    "bytes"
+    "html"
+    "strings"
+    "unicode/utf8"
+)
+
+var commentStart = []byte("<!--")
+var commentEnd = []byte("-->")
+var commentBangEnd = []byte("--!>")
+
+// tokenKind identifies what a token is.
+type tokenKind int
+
+const (
+    textToken tokenKind = iota // A single character of text
+    entityToken                // A character reference such as &amp;
+    startTagToken              // <name ...>
+    endTagToken                // </name>
+    commentToken               // <!-- ... -->
+    directiveToken             // <!DOCTYPE ...>, <?xml ...?> and the like
+)
+
+// token is a single piece of text or markup read from the input.
+type token struct {
+    kind        tokenKind
+    start       int    // Offset of the first byte of the token
+    end         int    // Offset just past the last byte of the token
+    name        string // Lowercased element name, for start and end tags
+    selfClosing bool   // Start tag written in the XHTML <name /> form
+    r           rune   // The character, for text and entity tokens
+}
+
+// readToken reads the token that begins at buf[pos]. A '<' or '&' that does
+// not start any markup is returned as ordinary text.
+func readToken(buf []byte, pos int) token {
+    kind, end, nameStart, nameEnd := scanToken(buf, pos)
+    tok := token{kind: kind, start: pos, end: end}
+
+    switch kind {
+    case startTagToken, endTagToken:
+        tok.name = strings.ToLower(string(buf[nameStart:nameEnd]))
+        tok.selfClosing = kind == startTagToken && buf[end-2] == '/'
+
+    case entityToken:
+        // Unknown entities are rendered literally, starting with '&'.
+        entity := string(buf[pos:end])
+        tok.r = '&'
+        if decoded := html.UnescapeString(entity); decoded != entity {
+            tok.r, _ = utf8.DecodeRuneInString(decoded)
+        }
+
+    case textToken:
+        tok.r, _ = utf8.DecodeRune(buf[pos:end])
+    }
+    return tok
+}
+
+// scanToken finds the end of the token that begins at buf[pos], along with
+// the offsets of the element name for start and end tags, without decoding
+// anything. Unlike readToken, it never allocates.
+func scanToken(buf []byte, pos int) (kind tokenKind, end, nameStart, nameEnd int) {
+    rest := buf[pos:]
+
+    switch rest[0] {
+    case '<':
+        if bytes.HasPrefix(rest, commentStart) {
+            return commentToken, pos+commentLen(rest), 0, 0
+        }
+
+        // Markup declarations and processing instructions, along with end
+        // tags that do not start with a letter, such as </> or </ b>, are
+        // skipped over by browsers without rendering anything.
+        if len(rest) > 1 && (rest[1] == '!' || rest[1] == '?') ||
+           len(rest) > 2 && rest[1] == '/' && !isASCIILetter(rest[2]) {
+            end := bytes.IndexByte(rest, '>')
+            if end < 0 {
+                return directiveToken, len(buf), 0, 0
+            }
+            return directiveToken, pos+end+1, 0, 0
+        }
+
+        if start, stop := tagNameAt(rest); stop > 0 {
+            if end := tagEnd(rest, stop); end > 0 {
+                kind := startTagToken
+                if start == 2 {
+                    kind = endTagToken
+                }
+                return kind, pos+end, pos+start, pos+stop
+            }
+        }
+
+    case '&':
+        if end := entityLen(rest); end > 0 {
+            return entityToken, pos+end, 0, 0
+        }
+    }
+
+    _, size := utf8.DecodeRune(rest)
+    return textToken, pos+size, 0, 0
+}
+
+// tagNameAt returns the offsets of the element name of the start or end tag
+// at the start of buf, or 0, 0 if buf doesn't start with one. Only the start
+// of the tag is checked; its end is found with tagEnd, which understands
+// quotes. Names may contain the colons, periods, hyphens and underscores used
+// by custom elements and by namespaced markup such as Word's <o:p>.
+func tagNameAt(buf []byte) (start, end int) {
+    start = 1
+    if len(buf) > 1 && buf[1] == '/' {
+        start = 2
+    }
+    if start >= len(buf) || !isASCIIAlnum(buf[start]) {
+        return 0, 0
+    }
+    end = start+1
+    for end < len(buf) && (isASCIIAlnum(buf[end]) || buf[end] == ':' || buf[end] == '.' ||
+                           buf[end] == '_' || buf[end] == '-') {
+        end++
+    }
+    return start, end
+}
+
+// entityLen returns the length of the character reference at the start of
+// buf, matching EntityExpr, or 0 if buf doesn't start with one.
+func entityLen(buf []byte) int {
+    i := 1
+    if i < len(buf) && buf[i] == '#' {
+        i++
+    }
+    start := i
+    for i < len(buf) && isASCIIAlnum(buf[i]) {
+        i++
+    }
+    if i == start || i >= len(buf) || buf[i] != ';' {
+        return 0
+    }
+    return i+1
+}
+
+// isASCIILetter reports whether c is an ASCII letter.
+func isASCIILetter(c byte) bool {
+    return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// isASCIIAlnum reports whether c is an ASCII letter or digit.
+func isASCIIAlnum(c byte) bool {
+    return isASCIILetter(c) || '0' <= c && c <= '9'
+}
+
+// tagEnd returns the offset just past the '>' that ends the tag starting at
+// buf[0], beginning the search at buf[from]. A '<' or '>' inside a quoted
+// attribute value does not end the tag. If the tag is never closed, tagEnd
+// returns -1.
+func tagEnd(buf []byte, from int) int {
+    var quote byte
+    var prev byte
+    for i := from; i < len(buf); i++ {
+        c := buf[i]
+        switch {
+        case quote != 0:
+            if c == quote {
+                quote = 0
+            }
+        case c == '>':
+            return i+1
+        case (c == '"' || c == '\'') && prev == '=':
+            quote = c
+        }
+        if !isSpaceByte(c) {
+            prev = c
+        }
+    }
+    return -1
+}
+
+// commentLen returns the length of the comment at the start of buf. It
+// follows the HTML parsing rules: the comment ends at the first "-->" or
+// "--!>", double hyphens inside it are allowed, "<!-->" and "<!--->" are
+// complete empty comments, and an unterminated comment runs to the end of
+// the input.
+func commentLen(buf []byte) int {
+    body := buf[len(commentStart):]
+    if bytes.HasPrefix(body, []byte(">")) {
+        return len(commentStart)+1
+    }
+    if bytes.HasPrefix(body, []byte("->")) {
+        return len(commentStart)+2
+    }
+
+    end := bytes.Index(body, commentEnd)
+    if end >= 0 {
+        end += len(commentEnd)
+    }
+    if bang := bytes.Index(body, commentBangEnd); bang >= 0 && (end < 0 || bang < end) {
+        end = bang+len(commentBangEnd)
+    }
+    if end < 0 {
+        return len(buf)
+    }
+    return len(commentStart)+end
+}
+
+// attribute is a single attribute of a start tag. The value has its
+// character references decoded.
+type attribute struct {
+    name  string // Lowercased attribute name
+    value string
+}
+
+// tagAttributes parses the attributes of the start tag in raw.
+func tagAttributes(raw []byte) []attribute {
+    _, i := tagNameAt(raw)
+    if i == 0 {
+        return nil
+    }
+
+    var attrs []attribute
+    for i < len(raw) {
+        // Skip whitespace and stray slashes between attributes.
+        for i < len(raw) && (isSpaceByte(raw[i]) || raw[i] == '/') {
+            i++
+        }
+        if i >= len(raw) || raw[i] == '>' {
+            break
+        }
+
+        start := i
+        for i < len(raw) && !isSpaceByte(raw[i]) && raw[i] != '=' && raw[i] != '>' && raw[i] != '/' {
+            i++
+        }
+        attr := attribute{name: string(bytes.ToLower(raw[start:i]))}
+
+        for i < len(raw) && isSpaceByte(raw[i]) {
+            i++
+        }
+        if i < len(raw) && raw[i] == '=' {
+            i++
+            for i < len(raw) && isSpaceByte(raw[i]) {
+                i++
+            }
+            var value []byte
+            if i < len(raw) && (raw[i] == '"' || raw[i] == '\'') {
+                quote := raw[i]
+                end := bytes.IndexByte(raw[i+1:], quote)
+                if end < 0 {
+                    end = len(raw)-i-1
+                }
+                value = raw[i+1:i+1+end]
+                i += end+2
+            } else {
+                start := i
+                for i < len(raw) && !isSpaceByte(raw[i]) && raw[i] != '>' {
+                    i++
+                }
+                value = raw[start:i]
+            }
+            attr.value = html.UnescapeString(string(value))
+        }
+        attrs = append(attrs, attr)
+    }
+    return attrs
+}
+
+// isSpaceByte reports whether c is HTML whitespace.
+func isSpaceByte(c byte) bool {
+    return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
@@ -21,153 +21,1907 @@
 package truncatehtml
 
 import (
+    "bytes"
     "errors"
-    "fmt"
+    "html"
+    "math"
     "regexp"
+    "strings"
     "unicode"
     "unicode/utf8"
 )
 
 var UnbalancedTagsError = errors.New("unbalanced tags")
-var TagExpr = regexp.MustCompile("<(/?)([A-Za-z0-9]+).*?>")
+var EllipsisUnbalancedError = errors.New("unbalanced tags in ellipsis")
+var InputTooLargeError = errors.New("input too large")
+var TagExpr = regexp.MustCompile("<(/?)([A-Za-z0-9][A-Za-z0-9:._-]*).*?>")
 var EntityExpr = regexp.MustCompile("&#?[A-Za-z0-9]+;")
 
+// CountMode selects which characters count toward the visible length.
+type CountMode int
+
+const (
+    // PrintableNonSpace counts every printable character that is not a space.
+    // This is the default.
+    PrintableNonSpace CountMode = iota
+
+    // AlphanumericOnly counts only letters and digits. Punctuation, symbols
+    // and spaces are copied to the output but do not count.
+    AlphanumericOnly
+)
+
+// counts reports whether the rune r is a visible character under mode.
+func (mode CountMode) counts(r rune) bool {
+    if mode == AlphanumericOnly {
+        return unicode.IsLetter(r) || unicode.IsDigit(r)
+    }
+    return unicode.IsPrint(r) && !unicode.IsSpace(r) && !isVariationSelector(r)
+}
+
+// isVariationSelector reports whether r is one of the variation selectors
+// that pick the text or emoji form of the character before it, as in the
+// envelope emoji "\u2709\ufe0f". They are part of that character's glyph.
+func isVariationSelector(r rune) bool {
+    return r == '\ufe0e' || r == '\ufe0f'
+}
+
+// zeroWidth are the characters that render nothing and never count, even
+// when written as entities such as &zwj;.
+var zeroWidth = map[rune]bool{
+    '\u200b': true, // Zero width space
+    '\u200c': true, // Zero width non-joiner
+    '\u200d': true, // Zero width joiner
+    '\u2060': true, // Word joiner
+    '\ufe0e': true, // Variation selector 15, text presentation
+    '\ufe0f': true, // Variation selector 16, emoji presentation
+}
+
+// countsToken reports whether the text or entity token tok is a visible
+// character under mode. By default every entity other than a zero-width one
+// counts; in AlphanumericOnly mode the entity's character is classified like
+// any other.
+func (mode CountMode) countsToken(tok token) bool {
+    if zeroWidth[tok.r] {
+        return false
+    }
+    if tok.kind == entityToken && mode != AlphanumericOnly {
+        return true
+    }
+    return mode.counts(tok.r)
+}
+
+// AtomicMode selects what happens when the limit is reached inside an element
+// that should not be split.
+type AtomicMode int
+
+const (
+    // AtomicOff lets the element be split like any other. This is the
+    // default.
+    AtomicOff AtomicMode = iota
+
+    // AtomicExclude drops the whole element if it does not fit.
+    AtomicExclude
+
+    // AtomicInclude keeps the whole element even though it goes past maxlen.
+    AtomicInclude
+)
+
+// WordCutStrategy selects where the output ends when the limit is reached in
+// the middle of a word.
+type WordCutStrategy int
+
+const (
+    // CutAtBoundary cuts the word at the character where the limit was
+    // reached, or, with WordBoundary set, acts like CutBefore. This is the
+    // default.
+    CutAtBoundary WordCutStrategy = iota
+
+    // CutBefore ends the output before the word, as WordBoundary does.
+    CutBefore
+
+    // CutAfter completes the word, going past maxlen by up to WordOvershoot
+    // visible characters. A word that would need more falls back to
+    // CutBefore.
+    CutAfter
+)
+
+// Action tells the truncator how to handle an end tag that does not match the
+// innermost open element.
+type Action int
+
+const (
+    // Fail stops truncation with UnbalancedTagsError. This is the default.
+    Fail Action = iota
+
+    // DropCloseTag removes the end tag from the output and carries on.
+    DropCloseTag
+
+    // PopToMatch closes the elements opened inside the matching open
+    // element, then closes that element. An end tag with no matching open
+    // element is removed from the output.
+    PopToMatch
+)
+
+// VoidStyle selects how void elements such as <br> are written out.
+type VoidStyle int
+
+const (
+    // VoidAsIs copies void elements as they appear in the input. This is the
+    // default.
+    VoidAsIs VoidStyle = iota
+
+    // VoidHTML5 writes void elements without a closing slash, as in <br>.
+    VoidHTML5
+
+    // VoidXHTML writes void elements self-closed, as in <br />.
+    VoidXHTML
+)
+
+// Options controls the behavior of TruncateHtmlWithOptions. The zero value
+// behaves exactly like TruncateHtml.
+type Options struct {
+    // CountMode selects which characters count toward maxlen.
+    CountMode CountMode
+
+    // CountWhitespace makes whitespace in text count toward maxlen as well,
+    // one character per rune. Without it, input made up only of whitespace
+    // has no visible characters and is kept whole; with it, such input is
+    // cut at maxlen runes like any other text.
+    CountWhitespace bool
+
+    // IsVisible, if set, decides which characters of text count toward
+    // maxlen, in place of CountMode and CountWhitespace. Entities are passed
+    // the character they stand for.
+    IsVisible func(r rune) bool
+
+    // RawEllipsis appends the ellipsis verbatim instead of HTML-escaping it.
+    // Set this when the ellipsis intentionally contains markup or entities.
+    RawEllipsis bool
+
+    // ValidateEllipsis checks a RawEllipsis for unbalanced tags, such as an
+    // unclosed "<b>", and returns EllipsisUnbalancedError if it has any.
+    ValidateEllipsis bool
+
+    // EllipsisOnlyWhenTruncated appends the ellipsis only when visible
+    // content was actually dropped. When the whole input fits within maxlen
+    // the input is returned unchanged, apart from closing any tags that it
+    // leaves open.
+    EllipsisOnlyWhenTruncated bool
+
+    // WordBoundary avoids cutting a word in half. If the limit is reached in
+    // the middle of a word, the output is shortened to end at the whitespace
+    // before that word, or at a <wbr> word break opportunity inside it.
+    WordBoundary bool
+
+    // WordBoundaryFallbackToChar applies when WordBoundary is set and the
+    // first word alone exceeds maxlen. Rather than returning empty output,
+    // the word is cut mid-word and the ellipsis is appended as usual.
+    WordBoundaryFallbackToChar bool
+
+    // WordCut selects where to cut a word that the limit falls inside. The
+    // default leaves that to WordBoundary.
+    WordCut WordCutStrategy
+
+    // WordOvershoot is the number of visible characters past maxlen that
+    // CutAfter may add to complete a word.
+    WordOvershoot int
+
+    // KeepPunctuation keeps punctuation with the word it is attached to when
+    // cutting at a word boundary. Closing punctuation such as ")" or ".\""
+    // right after the last word is kept even though it goes past maxlen, and
+    // opening punctuation such as "(" is never left without its word.
+    KeepPunctuation bool
+
+    // StripComments removes HTML comments from the output. Comments never
+    // count toward maxlen either way.
+    StripComments bool
+
+    // RenderedWhitespace counts whitespace the way a browser renders it: a
+    // run of whitespace between two visible characters counts as a single
+    // character, while leading and trailing whitespace, and text nodes made
+    // up only of whitespace between two tags, do not count.
+    RenderedWhitespace bool
+
+    // Ruby controls whether a <ruby> annotation may be split. With
+    // AtomicExclude or AtomicInclude the whole annotation is kept or dropped.
+    Ruby AtomicMode
+
+    // SkipRubyText stops the readings in <rt> and <rp> elements from counting
+    // toward maxlen, so only the base text counts.
+    SkipRubyText bool
+
+    // RespectHidden stops the content of elements that browsers don't
+    // render, those with the hidden attribute or with display:none in their
+    // style attribute, from counting toward maxlen. Their markup is still
+    // copied to the output.
+    RespectHidden bool
+
+    // MediaWeight, when positive, makes every <img>, <video> and <iframe>
+    // count as that many visible characters, so that a preview balances text
+    // and media. A media element that would go past maxlen is left out.
+    MediaWeight int
+
+    // MaxImages, when positive, ends the output right after the given number
+    // of images, each an <img> or a <picture> along with the <img> inside
+    // it. Elements open at that point are closed as usual.
+    MaxImages int
+
+    // DropDanglingTerms keeps a definition list term from being shown without
+    // its definition. If the cut falls in a <dt>, or after it but before the
+    // following <dd> starts, the output is cut before that <dt> instead.
+    DropDanglingTerms bool
+
+    // IncludeTrailingVoids copies void elements such as <br> and <img> that
+    // immediately follow the last visible character into the output, rather
+    // than stopping right before them.
+    IncludeTrailingVoids bool
+
+    // CountAttrText lists attributes, such as "alt", "title" and
+    // "aria-label", whose text counts toward maxlen when they appear on a
+    // void element like <img>. An element whose attribute text would go past
+    // maxlen is left out.
+    CountAttrText []string
+
+    // CountFormValues counts the value attribute of an <input> whose value is
+    // shown as text, such as a text field or a submit button, toward maxlen
+    // like the text of a <button>. Hidden inputs, checkboxes, radio buttons
+    // and the like don't count. An input whose value would go past maxlen is
+    // left out.
+    CountFormValues bool
+
+    // DisplayWidth counts East Asian wide and fullwidth characters, such as
+    // CJK ideographs, as two characters, matching how much room they take up
+    // on a display. A wide character that would go past maxlen is left out.
+    DisplayWidth bool
+
+    // AtomicTags lists elements, such as "figure", that must be kept whole:
+    // an element that does not fit within maxlen is left out entirely rather
+    // than being cut in the middle.
+    AtomicTags []string
+
+    // AtomicCodeBlocks keeps <pre> blocks, such as the <pre><code> blocks
+    // Markdown renders for code fences, whole: a block that does not fit
+    // within maxlen is left out and the output stops before it.
+    AtomicCodeBlocks bool
+
+    // AtomicSVG keeps inline <svg> images whole, so that the output never
+    // ends partway through their shapes or <text> elements: an image that
+    // does not fit within maxlen is left out and the output stops before it.
+    AtomicSVG bool
+
+    // PairedComments lists pairs of comment markers that delimit a region,
+    // such as {"$", "/$"} for the <!--$--> and <!--/$--> comments React emits
+    // around suspense boundaries. A marker matches a comment whose text, with
+    // surrounding whitespace removed, is exactly the marker. Regions still
+    // open at the cut are closed like elements. Markers must nest properly
+    // with the elements around them.
+    PairedComments [][2]string
+
+    // MinVisible is the smallest number of visible characters to keep. When
+    // maxlen is lower, MinVisible is used in its place, so even a maxlen of
+    // zero keeps some content.
+    MinVisible int
+
+    // RequiredOuterTag names an element, such as "div", that wraps the whole
+    // fragment and must be kept even when none of its content is. If buf
+    // begins, after any whitespace, with a start tag of that name, the output
+    // keeps the element even when maxlen is zero or no word boundary fits,
+    // giving an empty <div ...></div> rather than nothing at all.
+    RequiredOuterTag string
+
+    // VoidStyle selects how void elements are written to the output.
+    VoidStyle VoidStyle
+
+    // LowercaseTags writes element names in lowercase, including in the
+    // closing tags added by truncation, which otherwise match the case of
+    // their start tags. Attributes are copied as they are.
+    LowercaseTags bool
+
+    // XHTML writes XHTML-style markup. It implies LowercaseTags and
+    // VoidXHTML, and also writes attribute names in lowercase, quotes
+    // unquoted attribute values and expands minimized attributes, so that
+    // <input CHECKED> becomes <input checked="checked" />.
+    XHTML bool
+
+    // StripTags lists elements, by lowercase name, whose tags are removed
+    // from the output. Stripped void elements such as <img> leave nothing
+    // behind and don't count toward maxlen; other stripped elements keep
+    // their content.
+    StripTags map[string]bool
+
+    // OnUnbalanced, if set, is called when an end tag does not match the
+    // innermost open element, and chooses what to do about it. expected is
+    // the name of the innermost open element, or "" if there is none, found
+    // is the name in the end tag and stack holds the names of all the open
+    // elements, outermost first. It may be called more than once for the
+    // same end tag.
+    OnUnbalanced func(expected, found string, stack []string) Action
+
+    // KeepInterBlockWhitespace applies when StripComments or StripTags
+    // rebuilds the output. By default, text made up only of whitespace
+    // between two block-level tags, such as the line break in
+    // "</p>\n<p>", is dropped along with the removed markup around it. Set
+    // KeepInterBlockWhitespace to copy it to the output as is.
+    KeepInterBlockWhitespace bool
+
+    // ASCIIOnly speeds up truncation of content known to be ASCII by reading
+    // and classifying text a byte at a time, without decoding UTF-8. Any
+    // non-ASCII character found is still handled correctly, just without the
+    // speedup, so the output is the same either way.
+    ASCIIOnly bool
+
+    // SentenceBoundary ends the output after the last complete sentence that
+    // fits within maxlen. If not even the first sentence fits, the output is
+    // cut as it would be without SentenceBoundary.
+    SentenceBoundary bool
+
+    // SentenceTerminators lists the characters that end a sentence for
+    // SentenceBoundary. The default is '.', '!' and '?'. An ASCII
+    // terminator directly followed by a letter or digit, as in "3.14", does
+    // not end a sentence; other terminators, such as the Japanese '。', always
+    // do.
+    SentenceTerminators []rune
+
+    // MaxOutputBytes caps the length of the output in bytes, including the
+    // ellipsis and the closing tags. Content stops early enough to leave room
+    // for the ellipsis. If the markup that must be kept leaves too little
+    // room for the whole ellipsis, it is shortened a character at a time, or
+    // dropped; a RawEllipsis is never split and is dropped instead. Zero
+    // means no limit.
+    MaxOutputBytes int
+
+    // NoAutoClose leaves elements that are open at the cut open, without
+    // appending closing tags for them. Use it when the caller balances the
+    // output itself, for example when it is placed inside a known element.
+    NoAutoClose bool
+
+    // StripControlChars drops control characters, such as NUL and BEL, from
+    // the text copied to the output. HTML whitespace like tab and newline is
+    // kept. Control characters never count toward maxlen either way.
+    StripControlChars bool
+
+    // SanitizeInvalidUTF8 replaces each byte of buf that is not part of valid
+    // UTF-8, such as Latin-1 text pasted into UTF-8 content, with U+FFFD
+    // before truncating, so that the output is always valid UTF-8. Each
+    // replacement counts as one visible character, as the invalid byte
+    // would have.
+    SanitizeInvalidUTF8 bool
+
+    // NormalizeEntityCase rewrites named character references written in the
+    // wrong case, such as &COPY; or &NBSP;, to their canonical lowercase
+    // form. Names whose case changes their meaning, like &Alpha; and
+    // &alpha;, are left alone in any case, as in &ALPHA;, and so are names
+    // that aren't known in any case.
+    NormalizeEntityCase bool
+
+    // BalanceDelimiters keeps truncation from leaving a parenthesis, bracket
+    // or quotation mark in the visible text open: if content was dropped
+    // and the output has an opening delimiter whose closing one was cut
+    // off, the output ends before that opening delimiter instead.
+    BalanceDelimiters bool
+
+    // DisableEntities treats every '&' as an ordinary character, for input
+    // such as pre-escaped text where it never starts a character reference.
+    // "&amp;" then counts as five visible characters and may be cut anywhere.
+    DisableEntities bool
+
+    // MaxInputBytes, if positive, is the largest input accepted. Longer input
+    // is rejected with InputTooLargeError before any of it is read, to guard
+    // services against untrusted input. The default of 0 means no limit.
+    MaxInputBytes int
+}
+
 // TruncateHtml will truncate a given byte slice to a maximum of maxlen visible
 // characters and optionally append ellipsis. HTML tags are automatically closed
-// generating valid truncated HTML.
+// generating valid truncated HTML. The ellipsis is HTML-escaped before it is
+// appended; use TruncateHtmlWithOptions with RawEllipsis to insert markup.
+// The visible content kept for maxlen is always a prefix of the content kept
+// for maxlen+1, so raising the limit never removes text already shown. Any
+// maxlen at least as large as the visible length of buf, up to math.MaxInt,
+// keeps all of buf.
 func TruncateHtml(buf []byte, maxlen int, ellipsis string) ([]byte, error) {
-    // Here's the gist: Scan the input bytestream. While scanning, count the
-    // number of visible characters--that is, characters which are not part of
-    // markup tags. When a start tag is encountered, push the tag name onto a
-    // stack. When visible character count >= maxlen, or the EOF is reached,
-    // stop counting. Copy from the input stream the bytes from the start to the
-    // current scanning pointer. Finally, pop each tag off the tag stack and
-    // append it to the output stream in the form of a closing tag.
+    // Many inputs contain no markup at all. Those can be cut with a single
+    // pass over the runes, skipping the tag and entity machinery.
+    if bytes.IndexByte(buf, '<') < 0 && bytes.IndexByte(buf, '&') < 0 {
+        return truncatePlain(buf, maxlen, ellipsis), nil
+    }
+    return TruncateHtmlWithOptions(buf, maxlen, ellipsis, Options{})
+}
 
-    // We will consider HTML or XHTML as valid input. The following elements,
-    // called "Void Elements" need not conform to the XHTML <tag /> convention
-    // of void elements and may appear simply as <tag>. Hence, if one of the
-    // following is picked up by the tag expression as a start tag, do not add
-    // it to the stack of tags that should be closed.
-    voidElementTags := []string{"area", "base", "br", "col", "embed", "hr",
-                                "img", "input", "keygen", "link", "meta", 
-                                "param", "source", "track", "wbr"}
+// AppendTruncateHtml appends the result of TruncateHtml(buf, maxlen, ellipsis)
+// to dst and returns the extended slice, like the append built-in. Reusing dst
+// across calls avoids allocating a new output buffer each time. dst must not
+// overlap buf. If an error is returned, dst is returned unchanged.
+func AppendTruncateHtml(dst []byte, buf []byte, maxlen int, ellipsis string) ([]byte, error) {
+    t := newTruncator(buf, maxlen, Options{})
+    t.out = dst
+    result, err := t.run(ellipsis)
+    if err != nil {
+        return dst, err
+    }
+    return result.output, nil
+}
 
-    // Check to see if no input was provided.
-    if buf == nil || len(buf) == 0 || maxlen == 0 {
-        return []byte{}, nil
+// truncatePlain truncates buf, which must not contain '<' or '&', giving the
+// same result as TruncateHtmlWithOptions with the zero Options.
+func truncatePlain(buf []byte, maxlen int, ellipsis string) []byte {
+    if len(buf) == 0 || maxlen <= 0 {
+        return []byte{}
     }
 
-    tagStack := []string{}
+    // DecodeRune never reports a size past the end of its input, even for a
+    // multi-byte rune cut short at the end of buf, so cut stays within buf.
     visible := 0
-    bufPtr := 0
+    cut := 0
+    for cut < len(buf) && visible < maxlen {
+        r, size := utf8.DecodeRune(buf[cut:])
+        if PrintableNonSpace.counts(r) {
+            visible += 1
+        }
+        cut += size
+    }
+    for cut < len(buf) {
+        r, size := utf8.DecodeRune(buf[cut:])
+        if !isVariationSelector(r) {
+            break
+        }
+        cut += size
+    }
 
-    for bufPtr < len(buf) && visible < maxlen {
+    ellipsis = html.EscapeString(ellipsis)
+    output := make([]byte, 0, cut+len(ellipsis))
+    output = append(output, buf[:cut]...)
+    return append(output, ellipsis...)
+}
 
-        // Move to nearest tag and count visible characters along the way.
-        offset := 0
-        visibleCharacterMaxReached := false
-        entityDetected := false
+// TruncateHtmlRunes truncates buf to the first maxRunes runes of visible text.
+// Unlike TruncateHtml, every rune of text counts, including spaces and line
+// breaks; only markup is excluded. An entity such as &amp; counts as one rune.
+func TruncateHtmlRunes(buf []byte, maxRunes int, ellipsis string) ([]byte, error) {
+    return TruncateHtmlWithOptions(buf, maxRunes, ellipsis, Options{CountWhitespace: true})
+}
 
-        for localOffset, runeValue := range string(buf[bufPtr:]) {
-            offset = localOffset
+// TruncateHtmlWords truncates buf like TruncateHtml, but without cutting a
+// word in half: the output ends at the whitespace or <wbr> before the word
+// that would go past maxlen. If the first word alone goes past maxlen, it is
+// cut mid-word instead.
+func TruncateHtmlWords(buf []byte, maxlen int, ellipsis string) ([]byte, error) {
+    opts := Options{WordBoundary: true, WordBoundaryFallbackToChar: true}
+    return TruncateHtmlWithOptions(buf, maxlen, ellipsis, opts)
+}
 
-            if runeValue == '<' {
-                // Start of tag.
-                break
-            } else if runeValue == '&' {
-                // Possible start of HTML Entity
-                loc := EntityExpr.FindIndex(buf[bufPtr+localOffset:])
-                if loc != nil && loc[0] == 0 {
-                    // Entity found!
-                    entityDetected = true
-                    offset += loc[1]-1 // Now pointing to ;
+// TruncateHtmlWithOptions is like TruncateHtml, but its behavior can be
+// adjusted with opts.
+func TruncateHtmlWithOptions(buf []byte, maxlen int, ellipsis string, opts Options) ([]byte, error) {
+    result, err := truncate(buf, maxlen, ellipsis, opts)
+    if err != nil {
+        return nil, err
+    }
+    return result.output, nil
+}
+
+// openTag is an element that has been started but not yet closed.
+type openTag struct {
+    name   string
+    raw    []byte // The start tag exactly as it appeared in the input
+    closer string // For a paired comment marker, the comment that closes it
+    start  int    // Offset of the start tag in the input
+    out    int    // Offset of the start tag in the output
+}
+
+// truncation is the outcome of truncating a buffer.
+type truncation struct {
+    output    []byte    // Truncated HTML, including ellipsis and closing tags
+    content   int       // Length of output before the ellipsis
+    cut       int       // Number of input bytes copied to output
+    open      []openTag // Elements left open at the cut, outermost first
+    truncated bool      // Whether any visible content was dropped
+}
+
+// We will consider HTML or XHTML as valid input. The following elements,
+// called "Void Elements" need not conform to the XHTML <tag /> convention of
+// void elements and may appear simply as <tag>. Hence, if one of the following
+// is picked up by the tag expression as a start tag, do not add it to the
+// stack of tags that should be closed.
+var voidElements = map[string]bool{
+    "area": true, "base": true, "br": true, "col": true, "embed": true,
+    "hr": true, "img": true, "input": true, "keygen": true, "link": true,
+    "meta": true, "param": true, "source": true, "track": true, "wbr": true,
+}
+
+// Block-level elements start on a new line when rendered.
+var blockElements = map[string]bool{
+    "address": true, "article": true, "aside": true, "blockquote": true,
+    "dd": true, "details": true, "dialog": true, "div": true, "dl": true,
+    "dt": true, "fieldset": true, "figcaption": true, "figure": true,
+    "footer": true, "form": true, "h1": true, "h2": true, "h3": true,
+    "h4": true, "h5": true, "h6": true, "header": true, "hgroup": true,
+    "hr": true, "li": true, "main": true, "nav": true, "ol": true, "p": true,
+    "pre": true, "section": true, "table": true, "tr": true, "ul": true,
+}
+
+// HTML lets the end tag of some elements be left out when the next sibling
+// starts. impliedEnds maps each such start tag to the open elements it
+// closes when one of them is innermost.
+var impliedEnds = map[string]map[string]bool{
+    "li": {"li": true},
+    "dt": {"dt": true, "dd": true},
+    "dd": {"dt": true, "dd": true},
+    "td": {"td": true, "th": true},
+    "th": {"td": true, "th": true},
+    "tr": {"tr": true, "td": true, "th": true},
+    "option": {"option": true},
+    "p": {"p": true},
+}
+
+// Elements whose end tag may be left out when their parent ends.
+var optionalEnds = map[string]bool{
+    "li": true, "dt": true, "dd": true, "td": true, "th": true, "tr": true,
+    "option": true, "p": true,
+}
+
+// truncator holds the state of a single truncation.
+type truncator struct {
+    buf     []byte
+    maxlen  int
+    opts    Options
+    pos     int       // Offset of the next unread byte of buf
+    out     []byte    // Output produced so far
+    stack   []openTag // Elements that are currently open
+    visible int       // Number of visible characters in out
+    last    rune      // The last character of text copied to out
+
+    // When truncating on word boundaries, the state at the last whitespace
+    // that followed a word.
+    boundary *checkpoint
+
+    // Set when scanning stopped before maxlen was reached because the next
+    // character would not fit, for example because no lines are left.
+    stopped bool
+
+    // With RenderedWhitespace, spacePending is set when whitespace has been
+    // seen since the last visible character. pendingAtNode is its value at
+    // the start of the current text node, and nodeHasText reports whether
+    // that text node contains anything besides whitespace.
+    spacePending  bool
+    pendingAtNode bool
+    nodeHasText   bool
+
+    // When lineWidth is set, visible characters are laid out in lines of at
+    // most lineWidth characters and no more than maxLines lines are output.
+    lineWidth int
+    maxLines  int
+    line      int // Index of the current line
+    col       int // Visible characters on the current line
+
+    // While inside an element that must not be split, the state before its
+    // start tag, its depth in the stack and how to handle it.
+    atomic      *checkpoint
+    atomicDepth int
+    atomicMode  AtomicMode
+
+    // The state before the first <dt> of a definition list entry whose <dd>
+    // has not started yet, and the depth of the list it belongs to.
+    term      *checkpoint
+    termDepth int
+
+    // Set when only counting, so that tokens are not copied to out.
+    discard bool
+
+    // Set when checking a fragment, so that an end tag with no matching start
+    // tag is never passed over as one whose start tag was left out.
+    strict bool
+
+    // While inside an element whose text does not count, its depth in the
+    // stack. Zero otherwise.
+    hiddenDepth int
+
+    // The state just after the start tag of the required outer element, if
+    // it was opened.
+    wrapper *checkpoint
+
+    // Whether the last token copied to the output was a block-level tag.
+    afterBlock bool
+
+    // The state just after the last complete sentence, and whether the last
+    // character copied ended a sentence.
+    sentence      *checkpoint
+    sentenceEnded bool
+
+    // With MaxOutputBytes, the number of bytes held back for the ellipsis.
+    reserve int
+
+    // With MaxImages, the number of images copied to the output.
+    images int
+
+    // With BalanceDelimiters, the delimiters copied to the output, each with
+    // the state just before it.
+    delims []delimiter
+
+    // If set, the width of each counted character, replacing the count of
+    // one, or two with DisplayWidth.
+    widthOf func(rune) int
+
+    // Whether buf is longer than MaxInputBytes.
+    tooLarge bool
+}
+
+// newTruncator returns a truncator for buf.
+func newTruncator(buf []byte, maxlen int, opts Options) *truncator {
+    if opts.XHTML {
+        opts.LowercaseTags = true
+        opts.VoidStyle = VoidXHTML
+    }
+    tooLarge := opts.MaxInputBytes > 0 && len(buf) > opts.MaxInputBytes
+    if opts.SanitizeInvalidUTF8 && !tooLarge && !utf8.Valid(buf) {
+        buf = sanitizeUTF8(buf)
+    }
+    return &truncator{buf: buf, maxlen: maxlen, opts: opts, out: []byte{}, tooLarge: tooLarge}
+}
+
+// full reports whether no more visible characters may be copied.
+func (t *truncator) full() bool {
+    return t.visible >= t.maxlen || t.stopped
+}
+
+// checkpoint is a saved truncator state that can later be restored.
+type checkpoint struct {
+    pos     int
+    out     int
+    stack   []openTag
+    visible int
+}
+
+// save returns a checkpoint of the current state.
+func (t *truncator) save() *checkpoint {
+    stack := append([]openTag(nil), t.stack...)
+    return &checkpoint{t.pos, len(t.out), stack, t.visible}
+}
+
+// restore rewinds the truncator to the checkpoint c.
+func (t *truncator) restore(c *checkpoint) {
+    t.pos = c.pos
+    t.out = t.out[:c.out]
+    t.stack = c.stack
+    t.visible = c.visible
+}
+
+// step consumes the next token of the input, copying it to the output.
+func (t *truncator) step() error {
+    var tok token
+    if c := t.buf[t.pos]; c == '&' && t.opts.DisableEntities ||
+       t.opts.ASCIIOnly && c < utf8.RuneSelf && c != '<' && c != '&' {
+        tok = token{kind: textToken, start: t.pos, end: t.pos+1, r: rune(c)}
+    } else {
+        tok = readToken(t.buf, t.pos)
+    }
+    raw := t.buf[tok.start:tok.end]
+    if tok.kind == startTagToken || tok.kind == endTagToken {
+        raw = t.normalizeTag(tok, raw)
+    }
+    if tok.kind == entityToken && t.opts.NormalizeEntityCase {
+        raw, tok.r = normalizeEntity(raw, tok.r)
+    }
+    if t.opts.MaxOutputBytes > 0 && !t.discard && !t.fitsBytes(tok, raw) {
+        t.stopped = true
+        return nil
+    }
+    if t.sentenceEnded {
+        t.sentence = t.save()
+        t.sentenceEnded = false
+    }
+
+    if tok.kind != textToken && tok.kind != entityToken {
+        // Markup ends the current text node. If that node held nothing but
+        // whitespace it renders as nothing.
+        if !t.nodeHasText {
+            t.spacePending = t.pendingAtNode
+        }
+        t.pendingAtNode = t.spacePending
+        t.nodeHasText = false
+    }
+
+    switch tok.kind {
+    case textToken, entityToken:
+        if t.opts.StripControlChars && tok.kind == textToken && isStrippedControl(tok.r) {
+            t.pos = tok.end
+            return nil
+        }
+        if t.wordCut() != CutAtBoundary && tok.kind == textToken && t.visible > 0 &&
+           unicode.IsSpace(tok.r) && !unicode.IsSpace(t.last) &&
+           !isNoBreakSpace(tok.r) && !t.inside("nobr") {
+            t.boundary = t.save()
+        }
+        if t.afterBlock && tok.kind == textToken && unicode.IsSpace(tok.r) {
+            if end := t.interBlockWhitespace(); end > 0 {
+                t.pos = end
+                return nil
+            }
+        }
+        var before *checkpoint
+        if t.opts.BalanceDelimiters && t.hiddenDepth == 0 && isDelimiter(tok.r) {
+            before = t.save()
+        }
+        if t.hiddenDepth == 0 && t.counts(tok) {
+            if t.spacePending {
+                // Count the collapsed whitespace before this character. If
+                // that fills the limit, stop before the character.
+                t.spacePending = false
+                space := t.width(' ')
+                if t.visible+space > t.maxlen || !t.place() {
+                    t.stopped = true
+                    return nil
                 }
-                visible += 1
-            } else if unicode.IsPrint(runeValue) && !unicode.IsSpace(runeValue) {
-                // Printable, non-space character. Increment visible count.
-                visible += 1
+                t.visible += space
+                if t.full() {
+                    return nil
+                }
+            }
+            width := t.width(tok.r)
+            if t.visible+width > t.maxlen || !t.place() {
+                t.stopped = true
+                return nil
             }
+            t.visible += width
+            t.sentenceEnded = t.opts.SentenceBoundary && t.endsSentence(tok)
+        } else if t.opts.RenderedWhitespace && tok.kind == textToken &&
+                  unicode.IsSpace(tok.r) && t.visible > 0 {
+            t.spacePending = true
+        }
+        if before != nil {
+            t.delims = append(t.delims, delimiter{tok.r, before})
+        }
+        if !unicode.IsSpace(tok.r) {
+            t.nodeHasText = true
+        }
+        t.last = tok.r
 
-            // Check if the limit of visible characters has been reached.
-            if visible >= maxlen {
-                visibleCharacterMaxReached = true
-                break
+    case commentToken:
+        if t.opts.StripComments {
+            t.pos = tok.end
+            return nil
+        }
+        t.pairComment(raw)
+
+    case startTagToken:
+        if t.wordCut() != CutAtBoundary && tok.name == "wbr" && t.visible > 0 {
+            t.boundary = t.save()
+        }
+        if t.opts.StripTags[tok.name] {
+            t.pos = tok.end
+            return nil
+        }
+        // A block that starts after the last line would add a line of its own.
+        if blockElements[tok.name] && t.lineWidth > 0 && t.line >= t.maxLines {
+            t.stopped = true
+            return nil
+        }
+        if t.opts.MaxImages > 0 && (tok.name == "img" || tok.name == "picture") && !t.inside("picture") {
+            if t.images >= t.opts.MaxImages {
+                t.stopped = true
+                return nil
             }
+            t.images++
+        }
 
-            if entityDetected {
-                break
+        switch tok.name {
+        case "br":
+            t.breakLine()
+        case "dt":
+            if t.term == nil {
+                t.term = t.save()
+                t.termDepth = t.depthOf("dl")
+            }
+        case "dd":
+            if t.depthOf("dl") == t.termDepth {
+                t.term = nil
+                t.termDepth = 0
             }
         }
 
-        // Increment bufPtr to end of scanned section
-        bufPtr += offset
+        hidden := t.isHidden(tok, raw)
+        if weight := t.weightOf(tok, raw); weight > 0 && t.hiddenDepth == 0 && !hidden {
+            if weight > t.maxlen-t.visible {
+                t.stopped = true
+                return nil
+            }
+            t.visible += weight
+        }
 
-        // Stop scanning if the end of the buffer was reached or if the max
-        // desired visible characters was reached
-        if visibleCharacterMaxReached || bufPtr >= len(buf)-1 {
-            break
+        // Void and self-closing elements have no end tag to wait for.
+        if !tok.selfClosing && !voidElements[tok.name] {
+            t.closeImplied(tok.name)
+            if mode := t.atomicModeFor(tok.name); mode != AtomicOff && t.atomic == nil {
+                t.atomic = t.save()
+                t.atomicDepth = len(t.stack)+1
+                t.atomicMode = mode
+            }
+            t.stack = append(t.stack, openTag{name: tok.name, raw: raw, start: tok.start, out: len(t.out)})
+            if t.hiddenDepth == 0 && hidden {
+                t.hiddenDepth = len(t.stack)
+            }
+        }
+
+    case endTagToken:
+        // Void elements have no end tag, so one such as the </br> found in
+        // some malformed content means nothing and is dropped. So does the
+        // end tag of an element such as <p> that was already closed by an
+        // implied end tag, as browsers drop it.
+        if t.opts.StripTags[tok.name] || voidElements[tok.name] || t.strayEnd(tok.name) {
+            t.pos = tok.end
+            return nil
         }
 
-        // If an entity was detected, continue scanning for next tag
-        if entityDetected {
-            // Advance past the ;
-            bufPtr += 1
+        // First, check to make sure the end tag matches what's on top of the
+        // stack. Then pop the stack.
+        if len(t.stack) == 0 || t.stack[len(t.stack)-1].name != tok.name && !t.endImplied(tok.name) {
+            matched, err := t.unbalanced(tok)
+            if err != nil {
+                return err
+            }
+            if !matched {
+                t.pos = tok.end
+                return nil
+            }
+        }
+        t.pop()
+        if blockElements[tok.name] && t.col > 0 {
+            t.breakLine()
+        }
+    }
+
+    if !t.discard {
+        t.out = append(t.out, raw...)
+    }
+    if t.opts.MaxImages > 0 && t.images == t.opts.MaxImages && t.endsImage(tok) {
+        t.stopped = true
+    }
+    t.afterBlock = (tok.kind == startTagToken || tok.kind == endTagToken) && blockElements[tok.name]
+    t.pos = tok.end
+    return nil
+}
+
+// fitsBytes reports whether copying raw, the bytes of tok, leaves the output
+// within MaxOutputBytes once the closing tags are added, along with the bytes
+// reserved for the ellipsis if tok is text.
+func (t *truncator) fitsBytes(tok token, raw []byte) bool {
+    closers := t.closersLen()
+    switch tok.kind {
+    case commentToken:
+        if t.opts.StripComments {
+            return true
+        }
+    case startTagToken:
+        if t.opts.StripTags[tok.name] {
+            return true
+        }
+        if !tok.selfClosing && !voidElements[tok.name] && !t.opts.NoAutoClose {
+            closers += len(tok.name)+3
+        }
+    case endTagToken:
+        if t.opts.StripTags[tok.name] || voidElements[tok.name] || t.strayEnd(tok.name) {
+            return true
+        }
+        if n := len(t.stack); n > 0 && t.stack[n-1].name == tok.name && !t.opts.NoAutoClose {
+            closers -= len(tok.name)+3
+        }
+    }
+    size := len(t.out)+len(raw)+closers
+    if tok.kind == textToken || tok.kind == entityToken {
+        // Only content gives way to the ellipsis; markup that fits is kept
+        // and the ellipsis is shortened instead.
+        size += t.reserve
+    }
+    return size <= t.opts.MaxOutputBytes
+}
+
+// closersLen returns the length of the closing tags that will be added for
+// the open elements.
+func (t *truncator) closersLen() int {
+    if t.opts.NoAutoClose {
+        return 0
+    }
+    n := 0
+    for _, tag := range t.stack {
+        if tag.closer != "" {
+            n += len(tag.closer)
+        } else {
+            n += len(tag.name)+3
+        }
+    }
+    return n
+}
+
+// interBlockWhitespace checks whether the whitespace at t.pos, which follows
+// a block-level tag, should be dropped from rebuilt output. That is the case
+// when only whitespace and removed markup lie between it and the next
+// block-level tag. It returns the end of the whitespace, or -1 to keep it.
+func (t *truncator) interBlockWhitespace() int {
+    if t.opts.KeepInterBlockWhitespace || !t.opts.StripComments && len(t.opts.StripTags) == 0 {
+        return -1
+    }
+
+    end := -1
+    for pos := t.pos; pos < len(t.buf); {
+        tok := readToken(t.buf, pos)
+        switch {
+        case tok.kind == textToken && unicode.IsSpace(tok.r):
+            if end < 0 || end == pos {
+                end = tok.end
+            }
+        case tok.kind == commentToken && t.opts.StripComments:
+        case (tok.kind == startTagToken || tok.kind == endTagToken) && t.opts.StripTags[tok.name]:
+        case (tok.kind == startTagToken || tok.kind == endTagToken) && blockElements[tok.name]:
+            return end
+        default:
+            return -1
+        }
+        pos = tok.end
+    }
+    return -1
+}
+
+// counts reports whether the text or entity token tok counts toward maxlen.
+func (t *truncator) counts(tok token) bool {
+    if t.opts.IsVisible != nil {
+        return t.opts.IsVisible(tok.r)
+    }
+    if t.opts.ASCIIOnly && tok.kind == textToken && tok.r < utf8.RuneSelf {
+        return countsASCII(byte(tok.r), t.opts.CountMode, t.opts.CountWhitespace)
+    }
+    if t.opts.CountWhitespace && tok.kind == textToken && unicode.IsSpace(tok.r) {
+        return true
+    }
+    return t.opts.CountMode.countsToken(tok)
+}
+
+// normalizeTag rewrites the start or end tag raw as LowercaseTags and
+// VoidStyle require. A tag that needs no change is returned as is.
+func (t *truncator) normalizeTag(tok token, raw []byte) []byte {
+    style := VoidAsIs
+    if tok.kind == startTagToken && voidElements[tok.name] {
+        style = t.opts.VoidStyle
+    }
+    if !t.opts.LowercaseTags && style == VoidAsIs {
+        return raw
+    }
+
+    nameStart := 1
+    if tok.kind == endTagToken {
+        nameStart = 2
+    }
+    nameEnd := nameStart+len(tok.name)
+    rest := raw[nameEnd:len(raw)-1]
+    closing := false
+    switch style {
+    case VoidHTML5:
+        if bytes.HasSuffix(rest, []byte("/")) {
+            rest = bytes.TrimRight(rest[:len(rest)-1], " \t\n\r\f")
+        }
+    case VoidXHTML:
+        if !bytes.HasSuffix(rest, []byte("/")) {
+            rest = bytes.TrimRight(rest, " \t\n\r\f")
+            closing = true
+        }
+    }
+
+    output := make([]byte, 0, len(raw)+2)
+    output = append(output, raw[:nameStart]...)
+    if t.opts.LowercaseTags {
+        output = append(output, tok.name...)
+    } else {
+        output = append(output, raw[nameStart:nameEnd]...)
+    }
+    if t.opts.XHTML && tok.kind == startTagToken {
+        output = appendXHTMLAttributes(output, rest)
+    } else {
+        output = append(output, rest...)
+    }
+    if closing {
+        output = append(output, " /"...)
+    }
+    return append(output, '>')
+}
+
+// appendXHTMLAttributes appends the attributes of a start tag, the bytes
+// between its name and its '>', written as XHTML requires: names in
+// lowercase, values in quotes and minimized attributes such as disabled
+// written out as disabled="disabled". The order of the attributes and the
+// whitespace between them are kept.
+func appendXHTMLAttributes(output []byte, attrs []byte) []byte {
+    for i := 0; i < len(attrs); {
+        if isSpaceByte(attrs[i]) || attrs[i] == '/' {
+            output = append(output, attrs[i])
+            i++
+            continue
+        }
+
+        start := i
+        for i < len(attrs) && !isSpaceByte(attrs[i]) && attrs[i] != '=' && attrs[i] != '/' {
+            i++
+        }
+        name := bytes.ToLower(attrs[start:i])
+        output = append(output, name...)
+
+        eq := i
+        for eq < len(attrs) && isSpaceByte(attrs[eq]) {
+            eq++
+        }
+        if eq == len(attrs) || attrs[eq] != '=' {
+            output = append(output, "=\""...)
+            output = append(output, name...)
+            output = append(output, '"')
             continue
         }
+        i = eq+1
+        for i < len(attrs) && isSpaceByte(attrs[i]) {
+            i++
+        }
+        output = append(output, '=')
 
-        // Now find the expression sub-matches
-        matches := TagExpr.FindSubmatch(buf[bufPtr:])
-        tagName := string(matches[2])
+        if i < len(attrs) && (attrs[i] == '"' || attrs[i] == '\'') {
+            end := bytes.IndexByte(attrs[i+1:], attrs[i])
+            if end < 0 {
+                end = len(attrs)-i-1
+                output = append(output, attrs[i:]...)
+                output = append(output, attrs[i])
+            } else {
+                output = append(output, attrs[i:i+end+2]...)
+            }
+            i += end+2
+            continue
+        }
+        start = i
+        for i < len(attrs) && !isSpaceByte(attrs[i]) {
+            i++
+        }
+        output = append(output, '"')
+        output = append(output, bytes.ReplaceAll(attrs[start:i], []byte("\""), []byte("&quot;"))...)
+        output = append(output, '"')
+    }
+    return output
+}
 
-        // Advance pointer to the end of the tag
-        bufPtr += len(matches[0])
+// width returns the number of visible characters that the counted character
+// r takes up.
+func (t *truncator) width(r rune) int {
+    if t.widthOf != nil {
+        return t.widthOf(r)
+    }
+    if t.opts.DisplayWidth && isWide(r) {
+        return 2
+    }
+    return 1
+}
 
-        // If this is a void element, do not count it as a start tag
-        isVoidElement := false
-        for _, voidElementTagName := range voidElementTags {
-            if tagName == voidElementTagName {
-                isVoidElement = true
-                break
+// mediaElements are the elements counted by Options.MediaWeight.
+var mediaElements = map[string]bool{"img": true, "video": true, "iframe": true}
+
+// weightOf returns the number of visible characters that the start tag tok,
+// whose bytes are raw, counts as.
+func (t *truncator) weightOf(tok token, raw []byte) int {
+    weight := 0
+    if t.opts.MediaWeight > 0 && mediaElements[tok.name] {
+        weight += t.opts.MediaWeight
+    }
+    if (len(t.opts.CountAttrText) > 0 || t.opts.CountFormValues) && voidElements[tok.name] {
+        attrs := tagAttributes(raw)
+        for _, attr := range attrs {
+            if t.countsAttr(attr.name) ||
+               t.opts.CountFormValues && attr.name == "value" && showsValue(tok.name, attrs) {
+                weight += t.textWeight(attr.value)
             }
         }
-        if isVoidElement {
+    }
+    return weight
+}
+
+// countsAttr reports whether the attribute with the given name is listed in
+// CountAttrText.
+func (t *truncator) countsAttr(name string) bool {
+    for _, counted := range t.opts.CountAttrText {
+        if strings.EqualFold(name, counted) {
+            return true
+        }
+    }
+    return false
+}
+
+// valuelessInputTypes are the <input> types that don't show their value as
+// text.
+var valuelessInputTypes = map[string]bool{
+    "hidden": true, "checkbox": true, "radio": true, "file": true,
+    "image": true, "range": true, "color": true,
+}
+
+// showsValue reports whether the element with the given name and attributes
+// shows its value attribute as text.
+func showsValue(name string, attrs []attribute) bool {
+    if name != "input" {
+        return false
+    }
+    for _, attr := range attrs {
+        if attr.name == "type" {
+            return !valuelessInputTypes[strings.ToLower(strings.TrimSpace(attr.value))]
+        }
+    }
+    return true
+}
+
+// textWeight returns the number of visible characters in the plain text s.
+func (t *truncator) textWeight(s string) int {
+    weight := 0
+    for _, r := range s {
+        if t.counts(token{kind: textToken, r: r}) {
+            weight += t.width(r)
+        }
+    }
+    return weight
+}
+
+// moreVisible reports whether anything after the current position would
+// count toward the visible length.
+func (t *truncator) moreVisible() bool {
+    for pos := t.pos; pos < len(t.buf); {
+        tok := readToken(t.buf, pos)
+        switch tok.kind {
+        case textToken, entityToken:
+            if t.counts(tok) {
+                return true
+            }
+        case startTagToken:
+            if t.weightOf(tok, t.buf[tok.start:tok.end]) > 0 {
+                return true
+            }
+        }
+        pos = tok.end
+    }
+    return false
+}
+
+// pop removes the innermost open element from the stack.
+func (t *truncator) pop() {
+    t.stack = t.stack[:len(t.stack)-1]
+    if len(t.stack) < t.atomicDepth {
+        t.atomic = nil
+        t.atomicDepth = 0
+    }
+    if len(t.stack) < t.hiddenDepth {
+        t.hiddenDepth = 0
+    }
+    if len(t.stack) < t.termDepth {
+        t.term = nil
+        t.termDepth = 0
+    }
+}
+
+// closeImplied pops the open elements whose end tag is implied by a start
+// tag of the named element, such as an open li when another li starts.
+func (t *truncator) closeImplied(name string) {
+    closes := impliedEnds[name]
+    for len(t.stack) > 0 && closes[t.stack[len(t.stack)-1].name] {
+        t.pop()
+    }
+}
+
+// endImplied pops the innermost open elements whose end tag was left out, if
+// that brings the named element to the top of the stack. It reports whether
+// it did.
+func (t *truncator) endImplied(name string) bool {
+    i := len(t.stack)-1
+    for i >= 0 && t.stack[i].name != name && optionalEnds[t.stack[i].name] {
+        i--
+    }
+    if i < 0 || i == len(t.stack)-1 || t.stack[i].name != name {
+        return false
+    }
+    for len(t.stack) > i+1 {
+        t.pop()
+    }
+    return true
+}
+
+// strayEnd reports whether an end tag with the given name belongs to an
+// element whose end tag may be left out, but no such element is open.
+func (t *truncator) strayEnd(name string) bool {
+    return !t.strict && optionalEnds[name] && !t.inside(name)
+}
+
+// unbalanced handles an end tag that does not match the innermost open
+// element, as chosen by Options.OnUnbalanced. It reports whether the end tag
+// now matches the innermost open element; if not, the end tag is dropped.
+func (t *truncator) unbalanced(tok token) (bool, error) {
+    action := Fail
+    if t.opts.OnUnbalanced != nil {
+        expected := ""
+        names := make([]string, len(t.stack))
+        for i, tag := range t.stack {
+            names[i] = tag.name
+            expected = tag.name
+        }
+        action = t.opts.OnUnbalanced(expected, tok.name, names)
+    }
+
+    switch action {
+    case DropCloseTag:
+        return false, nil
+    case PopToMatch:
+        for i := len(t.stack)-1; i >= 0; i-- {
+            if t.stack[i].name == tok.name {
+                if !t.discard {
+                    t.out = appendClosers(t.out, t.stack[i+1:])
+                }
+                for len(t.stack) > i+1 {
+                    t.pop()
+                }
+                return true, nil
+            }
+        }
+        return false, nil
+    }
+    return false, UnbalancedTagsError
+}
+
+// pairComment opens or closes a region if the comment raw is one of the
+// markers in Options.PairedComments. A closing marker that does not match the
+// innermost open region is left as an ordinary comment.
+func (t *truncator) pairComment(raw []byte) {
+    if len(t.opts.PairedComments) == 0 || !bytes.HasSuffix(raw, commentEnd) ||
+       len(raw) < len(commentStart)+len(commentEnd) {
+        return
+    }
+    marker := strings.TrimSpace(string(raw[len(commentStart):len(raw)-len(commentEnd)]))
+    for _, pair := range t.opts.PairedComments {
+        closer := string(commentStart) + pair[1] + string(commentEnd)
+        switch marker {
+        case pair[0]:
+            t.stack = append(t.stack, openTag{raw: raw, closer: closer, start: t.pos, out: len(t.out)})
+            return
+        case pair[1]:
+            if n := len(t.stack); n > 0 && t.stack[n-1].closer == closer {
+                t.pop()
+                return
+            }
+        }
+    }
+}
+
+// atomicModeFor returns how an element with the given name may be split.
+func (t *truncator) atomicModeFor(name string) AtomicMode {
+    if name == "ruby" {
+        return t.opts.Ruby
+    }
+    if name == "pre" && t.opts.AtomicCodeBlocks || name == "svg" && t.opts.AtomicSVG {
+        return AtomicExclude
+    }
+    for _, tag := range t.opts.AtomicTags {
+        if strings.EqualFold(name, tag) {
+            return AtomicExclude
+        }
+    }
+    return AtomicOff
+}
+
+// isHidden reports whether the content of the element started by tok, whose
+// bytes are raw, should not count toward maxlen.
+func (t *truncator) isHidden(tok token, raw []byte) bool {
+    if t.opts.SkipRubyText && (tok.name == "rt" || tok.name == "rp") {
+        return true
+    }
+    if !t.opts.RespectHidden {
+        return false
+    }
+    for _, attr := range tagAttributes(raw) {
+        if attr.name == "hidden" {
+            return true
+        }
+        if attr.name == "style" && hidesElement(attr.value) {
+            return true
+        }
+    }
+    return false
+}
+
+// hidesElement reports whether the CSS declarations in style include
+// display:none.
+func hidesElement(style string) bool {
+    for _, decl := range strings.Split(style, ";") {
+        property, value, found := strings.Cut(decl, ":")
+        if found && strings.EqualFold(strings.TrimSpace(property), "display") {
+            value = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "!important"))
+            if strings.EqualFold(value, "none") {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// finishAtomic is called when the limit is reached inside an element that
+// must not be split. It scans to the end of the element, then keeps it or
+// rewinds to before its start tag depending on the mode and whether the
+// element went past maxlen.
+func (t *truncator) finishAtomic() error {
+    start, maxlen := t.atomic, t.maxlen
+    t.maxlen, t.stopped = math.MaxInt, false
+    for t.pos < len(t.buf) && t.atomic != nil && !t.stopped {
+        if err := t.step(); err != nil {
+            return err
+        }
+    }
+    overflow := t.stopped
+    t.maxlen, t.stopped = maxlen, true
+
+    // An element cut short by MaxOutputBytes can't be kept whole.
+    if overflow || t.visible > maxlen && t.atomicMode == AtomicExclude {
+        t.restore(start)
+    }
+    t.atomic = nil
+    t.atomicDepth = 0
+    return nil
+}
+
+// place lays out the next visible character when output is limited to a
+// number of lines, and reports whether it fits.
+func (t *truncator) place() bool {
+    if t.lineWidth <= 0 {
+        return true
+    }
+    if t.col >= t.lineWidth {
+        t.breakLine()
+    }
+    if t.line >= t.maxLines {
+        return false
+    }
+    t.col += 1
+    return true
+}
+
+// breakLine starts a new line of output.
+func (t *truncator) breakLine() {
+    t.line += 1
+    t.col = 0
+}
+
+// defaultSentenceTerminators are the characters that end a sentence when
+// Options.SentenceTerminators is nil.
+var defaultSentenceTerminators = []rune{'.', '!', '?'}
+
+// endsSentence reports whether the counted character tok, just consumed,
+// ends a sentence.
+func (t *truncator) endsSentence(tok token) bool {
+    terminators := t.opts.SentenceTerminators
+    if terminators == nil {
+        terminators = defaultSentenceTerminators
+    }
+    for _, r := range terminators {
+        if r != tok.r {
             continue
         }
+        if r >= utf8.RuneSelf || tok.end == len(t.buf) {
+            return true
+        }
+        next := readToken(t.buf, tok.end)
+        return next.kind != textToken && next.kind != entityToken || !isWordRune(next.r)
+    }
+    return false
+}
 
-        isStartTag := len(matches[1]) == 0
+// delimiter is an opening or closing delimiter copied to the output, with the
+// state just before it.
+type delimiter struct {
+    r      rune
+    before *checkpoint
+}
 
-        if isStartTag {
-            // This is a start tag. Push the tag to the stack.
-            tagStack = append(tagStack, tagName)
-        } else {
-            // This is an end tag. First, check to make sure the end tag is
-            // matches what's on top of the stack.
-            if len(tagStack) == 0 || tagStack[len(tagStack)-1] != tagName {
-                return nil, UnbalancedTagsError
+// delimiterPairs maps each opening delimiter to its closing one.
+var delimiterPairs = map[rune]rune{
+    '(': ')', '[': ']', '{': '}', '"': '"', '\u201c': '\u201d', '\u00ab': '\u00bb',
+}
+
+// isDelimiter reports whether r opens or closes a delimiter pair.
+func isDelimiter(r rune) bool {
+    switch r {
+    case '(', ')', '[', ']', '{', '}', '"', '\u201c', '\u201d', '\u00ab', '\u00bb':
+        return true
+    }
+    return false
+}
+
+// unmatchedDelimiter returns the state before the first opening delimiter in
+// the output that is not closed, or nil if there is none. Delimiters that
+// were dropped by rewinding the output are ignored.
+func (t *truncator) unmatchedDelimiter() *checkpoint {
+    var open []delimiter
+    for _, d := range t.delims {
+        if d.before.out >= len(t.out) {
+            break
+        }
+        if n := len(open); n > 0 && delimiterPairs[open[n-1].r] == d.r {
+            open = open[:n-1]
+        } else if _, ok := delimiterPairs[d.r]; ok {
+            open = append(open, d)
+        }
+    }
+    if len(open) == 0 {
+        return nil
+    }
+    return open[0].before
+}
+
+// wordCut returns the word cut strategy in effect.
+func (t *truncator) wordCut() WordCutStrategy {
+    if t.opts.WordCut == CutAtBoundary && t.opts.WordBoundary {
+        return CutBefore
+    }
+    return t.opts.WordCut
+}
+
+// finishWord is called with CutAfter when the limit is reached inside a word.
+// It copies the rest of the word if that takes no more than WordOvershoot
+// visible characters, and otherwise leaves the state as it was.
+func (t *truncator) finishWord() error {
+    start, last, maxlen := t.save(), t.last, t.maxlen
+    t.maxlen, t.stopped = t.visible+t.opts.WordOvershoot, false
+    for t.pos < len(t.buf) && !t.full() && t.isMidWord() {
+        if err := t.step(); err != nil {
+            return err
+        }
+    }
+    if t.isMidWord() {
+        t.restore(start)
+        t.last = last
+    }
+    t.maxlen, t.stopped = maxlen, true
+    return nil
+}
+
+// finishPunctuation copies the closing punctuation that follows the last
+// word or punctuation mark in the output, along with any markup in between.
+func (t *truncator) finishPunctuation() error {
+    maxlen := t.maxlen
+    for t.pos < len(t.buf) && (isWordRune(t.last) || isClosingPunctuation(t.last)) {
+        next, ok := t.nextText()
+        if !ok || !isClosingPunctuation(next.r) {
+            break
+        }
+        t.maxlen, t.stopped = t.visible+1, false
+        for t.pos <= next.start && !t.stopped {
+            if err := t.step(); err != nil {
+                return err
             }
+        }
+        if t.pos <= next.start {
+            break
+        }
+    }
+    t.maxlen, t.stopped = maxlen, true
+    return nil
+}
 
-            // Now, pop the tag stack.
-            tagStack = tagStack[0:len(tagStack)-1]
+// nextText returns the next text or entity token after the current position,
+// skipping markup, and whether there is one.
+func (t *truncator) nextText() (token, bool) {
+    for pos := t.pos; pos < len(t.buf); {
+        tok := readToken(t.buf, pos)
+        if tok.kind == textToken || tok.kind == entityToken {
+            return tok, true
         }
+        pos = tok.end
     }
+    return token{}, false
+}
 
-    // At this point, bufPtr points to the last rune that should be copied to
-    // the output stream. Increment bufPtr past this rune, turning bufPtr into
-    // the number of bytes that should be copied.
-    _, size := utf8.DecodeRune(buf[bufPtr:])
-    bufPtr += size
+// isMidWord reports whether stopping at the current position would cut a
+// word in half, that is, whether the text on both sides of the cut is a
+// letter or digit. Markup after the cut is skipped. A no-break space joins
+// the words around it into one, and so does a <nobr> element: any cut
+// inside one with text left in it is mid-word.
+func (t *truncator) isMidWord() bool {
+    nobr := t.inside("nobr")
+    joined := isWordRune(t.last) || isNoBreakSpace(t.last) ||
+        t.opts.KeepPunctuation && isOpeningPunctuation(t.last)
+    if !nobr && !joined {
+        return false
+    }
+    for pos := t.pos; pos < len(t.buf); {
+        tok := readToken(t.buf, pos)
+        if tok.kind == textToken || tok.kind == entityToken {
+            return nobr || joined && (isWordRune(tok.r) || isNoBreakSpace(tok.r))
+        }
+        if tok.kind == startTagToken && tok.name == "wbr" {
+            return false
+        }
+        if tok.kind == endTagToken && tok.name == "nobr" {
+            nobr = false
+        }
+        pos = tok.end
+    }
+    return false
+}
+
+// endsImage reports whether tok, which was just copied, completes an image
+// counted by MaxImages.
+func (t *truncator) endsImage(tok token) bool {
+    switch {
+    case tok.kind == startTagToken && tok.name == "img":
+        return !t.inside("picture")
+    case tok.kind == startTagToken && tok.name == "picture":
+        return tok.selfClosing
+    case tok.kind == endTagToken && tok.name == "picture":
+        return !t.inside("picture")
+    }
+    return false
+}
+
+// inside reports whether an element with the given name is open.
+func (t *truncator) inside(name string) bool {
+    for _, tag := range t.stack {
+        if tag.name == name {
+            return true
+        }
+    }
+    return false
+}
+
+// depthOf returns the depth in the stack of the innermost open element with
+// the given name, or zero if there is none.
+func (t *truncator) depthOf(name string) int {
+    for i := len(t.stack)-1; i >= 0; i-- {
+        if t.stack[i].name == name {
+            return i+1
+        }
+    }
+    return 0
+}
+
+// normalizeEntity returns the canonical form of the character reference raw
+// and the character it stands for, given the character r it was read as. See
+// Options.NormalizeEntityCase. A known name is only rewritten if its
+// lowercase form means the same. An unknown one is only rewritten if neither
+// its capitalized nor its uppercase form means something else, so &EACUTE;
+// is left alone because it could be &Eacute; or &eacute;.
+func normalizeEntity(raw []byte, r rune) ([]byte, rune) {
+    lower := bytes.ToLower(raw)
+    if raw[1] == '#' || bytes.Equal(lower, raw) {
+        return raw, r
+    }
+    decoded := html.UnescapeString(string(lower))
+    if decoded == string(lower) {
+        return raw, r
+    }
+    variants := [][]byte{raw}
+    if original := html.UnescapeString(string(raw)); original == string(raw) {
+        title := append([]byte(nil), lower...)
+        if 'a' <= title[1] && title[1] <= 'z' {
+            title[1] -= 'a'-'A'
+        }
+        variants = append(variants, title, bytes.ToUpper(lower))
+    }
+    for _, variant := range variants {
+        if other := html.UnescapeString(string(variant)); other != string(variant) && other != decoded {
+            return raw, r
+        }
+    }
+    r, _ = utf8.DecodeRuneInString(decoded)
+    return lower, r
+}
+
+// sanitizeUTF8 returns a copy of buf with each byte that is not part of a
+// valid UTF-8 sequence replaced by U+FFFD.
+func sanitizeUTF8(buf []byte) []byte {
+    output := make([]byte, 0, len(buf)+len(buf)/2)
+    for pos := 0; pos < len(buf); {
+        r, size := utf8.DecodeRune(buf[pos:])
+        if r == utf8.RuneError && size == 1 {
+            output = utf8.AppendRune(output, utf8.RuneError)
+        } else {
+            output = append(output, buf[pos:pos+size]...)
+        }
+        pos += size
+    }
+    return output
+}
+
+// isStrippedControl reports whether r is a control character removed by
+// StripControlChars.
+func isStrippedControl(r rune) bool {
+    return unicode.IsControl(r) && !(r < utf8.RuneSelf && isSpaceByte(byte(r)))
+}
+
+// isNoBreakSpace reports whether r is a space that does not allow a line
+// break, such as &nbsp;.
+func isNoBreakSpace(r rune) bool {
+    return r == '\u00a0' || r == '\u2007' || r == '\u202f'
+}
 
-    // Copy the desired input to the output buffer.
-    output := buf[0:bufPtr]
+// truncate does the work for TruncateHtmlWithOptions and the functions built
+// on it.
+func truncate(buf []byte, maxlen int, ellipsis string, opts Options) (truncation, error) {
+    return newTruncator(buf, maxlen, opts).run(ellipsis)
+}
+
+// run truncates the input and appends ellipsis and the closing tags.
+func (t *truncator) run(ellipsis string) (truncation, error) {
+    // Here's the gist: Scan the input bytestream one token at a time, where a
+    // token is a character, an entity, a tag or a comment, copying each token
+    // to the output. While scanning, count the number of visible
+    // characters--that is, characters which are not part of markup. When a
+    // start tag is encountered, push the tag onto a stack, and pop it when the
+    // matching end tag is found. When visible character count >= maxlen, or
+    // the EOF is reached, stop. Finally, pop each tag off the tag stack and
+    // append it to the output stream in the form of a closing tag.
+
+    buf, opts := t.buf, t.opts
+    if t.tooLarge {
+        return truncation{}, InputTooLargeError
+    }
+    if opts.RawEllipsis && opts.ValidateEllipsis && !isBalanced([]byte(ellipsis)) {
+        return truncation{}, EllipsisUnbalancedError
+    }
+
+    // Check to see if no input was provided.
+    base := len(t.out)
+    if len(buf) == 0 {
+        return truncation{output: t.out}, nil
+    }
+    if t.maxlen < opts.MinVisible {
+        t.maxlen = opts.MinVisible
+    }
+    if opts.RequiredOuterTag != "" && t.openWrapper() {
+        t.wrapper = t.save()
+    }
+    if t.maxlen == 0 {
+        return t.emptyResult(base, false), nil
+    }
+    if opts.MaxOutputBytes > 0 {
+        t.reserve = len(ellipsis)
+        if !opts.RawEllipsis {
+            t.reserve = len(html.EscapeString(ellipsis))
+        }
+    }
 
-    // Copy ellipsis
+    for t.pos < len(buf) && !t.full() {
+        if err := t.step(); err != nil {
+            return truncation{}, err
+        }
+    }
+    limitReached := t.full()
+
+    // Keep a variation selector with the character before it.
+    for limitReached && !t.stopped && t.pos < len(buf) {
+        tok := readToken(buf, t.pos)
+        if tok.kind != textToken && tok.kind != entityToken || !isVariationSelector(tok.r) {
+            break
+        }
+        if err := t.step(); err != nil {
+            return truncation{}, err
+        }
+    }
+
+    // If the limit was reached inside an element that must not be split,
+    // finish the element or drop it.
+    if limitReached && t.atomic != nil {
+        if err := t.finishAtomic(); err != nil {
+            return truncation{}, err
+        }
+    }
+
+    // Back up to the end of the last complete sentence, unless the cut is
+    // already there.
+    sentenceCut := false
+    if opts.SentenceBoundary && limitReached && !t.sentenceEnded && t.sentence != nil && t.moreVisible() {
+        t.restore(t.sentence)
+        sentenceCut = true
+    }
+
+    // If the cut fell inside a word, complete the word or back up to the last
+    // word boundary.
+    wordCut := t.wordCut()
+    if wordCut == CutAfter && limitReached && !sentenceCut && t.isMidWord() {
+        if err := t.finishWord(); err != nil {
+            return truncation{}, err
+        }
+    }
+    if wordCut != CutAtBoundary && limitReached && !sentenceCut && t.isMidWord() {
+        if t.boundary != nil {
+            t.restore(t.boundary)
+        } else if !opts.WordBoundaryFallbackToChar {
+            return t.emptyResult(base, true), nil
+        }
+    }
+
+    // Keep closing punctuation with the last word.
+    if opts.KeepPunctuation && wordCut != CutAtBoundary && limitReached {
+        if err := t.finishPunctuation(); err != nil {
+            return truncation{}, err
+        }
+    }
+
+    // Don't leave a definition list term without its definition.
+    if opts.DropDanglingTerms && limitReached && t.term != nil {
+        t.restore(t.term)
+    }
+
+    // Don't leave a parenthesis or quotation open.
+    if opts.BalanceDelimiters && limitReached && t.moreVisible() {
+        if open := t.unmatchedDelimiter(); open != nil {
+            t.restore(open)
+        }
+    }
+
+    if opts.IncludeTrailingVoids && limitReached {
+        for t.pos < len(buf) {
+            tok := readToken(buf, t.pos)
+            if tok.kind != startTagToken || !voidElements[tok.name] {
+                break
+            }
+            if err := t.step(); err != nil || t.pos != tok.end {
+                break
+            }
+        }
+    }
+
+    truncated := limitReached && t.moreVisible()
+    if opts.EllipsisOnlyWhenTruncated && !truncated {
+        if limitReached {
+            // The limit was reached exactly and only markup remains. Copy
+            // the rest of the input rather than dropping that markup.
+            t.maxlen, t.stopped = math.MaxInt, false
+            for t.pos < len(buf) && !t.stopped {
+                if err := t.step(); err != nil {
+                    return truncation{}, err
+                }
+            }
+        }
+        ellipsis = ""
+    }
+
+    output := t.out
+    content := len(output)
+
+    // Copy ellipsis, escaping it unless the caller asked for it verbatim.
+    if opts.MaxOutputBytes > 0 {
+        ellipsis = fitEllipsis(ellipsis, opts.MaxOutputBytes-len(output)-t.closersLen(), opts.RawEllipsis)
+    }
+    if !opts.RawEllipsis {
+        ellipsis = html.EscapeString(ellipsis)
+    }
     output = append(output, []byte(ellipsis)...)
 
     // Finally, create a closing tag for each tag in the stack.
-    for i:=len(tagStack)-1; i >= 0; i-- {
-        output = append(output, []byte(fmt.Sprintf("</%s>", tagStack[i]))...)
+    if !opts.NoAutoClose {
+        output = appendClosers(output, t.stack)
+    }
+
+    return truncation{output, content, t.pos, t.stack, truncated}, nil
+}
+
+// fitEllipsis shortens ellipsis, dropping characters from its end, until it
+// takes no more than room bytes once escaped. A raw ellipsis is either kept
+// whole or dropped, since cutting it could split its markup.
+func fitEllipsis(ellipsis string, room int, raw bool) string {
+    if raw {
+        if len(ellipsis) > room {
+            return ""
+        }
+        return ellipsis
+    }
+    runes := []rune(ellipsis)
+    for n := len(runes); n > 0; n-- {
+        if len(html.EscapeString(string(runes[:n]))) <= room {
+            return string(runes[:n])
+        }
     }
+    return ""
+}
+
+// openWrapper copies the start tag of the RequiredOuterTag element to the
+// output, along with any whitespace before it, if buf begins with one. It
+// reports whether the element was opened.
+func (t *truncator) openWrapper() bool {
+    pos := t.pos
+    for pos < len(t.buf) && isSpaceByte(t.buf[pos]) {
+        pos++
+    }
+    if pos == len(t.buf) {
+        return false
+    }
+    tok := readToken(t.buf, pos)
+    if tok.kind != startTagToken || tok.selfClosing || voidElements[tok.name] ||
+       !strings.EqualFold(tok.name, t.opts.RequiredOuterTag) {
+        return false
+    }
+    raw := t.normalizeTag(tok, t.buf[tok.start:tok.end])
+    t.out = append(t.out, t.buf[t.pos:tok.start]...)
+    t.stack = append(t.stack, openTag{name: tok.name, raw: raw, start: tok.start, out: len(t.out)})
+    t.out = append(t.out, raw...)
+    t.pos = tok.end
+    return true
+}
+
+// emptyResult returns the result of dropping all of the content. That is the
+// output as it was before truncation began, or the empty required outer
+// element if it was opened.
+func (t *truncator) emptyResult(base int, truncated bool) truncation {
+    if t.wrapper == nil {
+        return truncation{output: t.out[:base], truncated: truncated}
+    }
+    t.restore(t.wrapper)
+    output := t.out
+    if !t.opts.NoAutoClose {
+        output = appendClosers(output, t.stack)
+    }
+    return truncation{output, len(t.out), t.pos, t.stack, truncated}
+}
+
+// appendClosers appends a closing tag for each element in open to output,
+// innermost first.
+func appendClosers(output []byte, open []openTag) []byte {
+    for i:=len(open)-1; i >= 0; i-- {
+        if open[i].closer != "" {
+            output = append(output, open[i].closer...)
+            continue
+        }
+        // raw starts with the name as it was written to the output.
+        output = append(output, "</"...)
+        output = append(output, open[i].raw[1:1+len(open[i].name)]...)
+        output = append(output, '>')
+    }
+    return output
+}
+
+// isBalanced reports whether every element opened in buf is also closed.
+func isBalanced(buf []byte) bool {
+    t := newTruncator(buf, math.MaxInt, Options{})
+    t.discard = true
+    t.strict = true
+    for t.pos < len(buf) {
+        if err := t.step(); err != nil {
+            return false
+        }
+    }
+    return len(t.stack) == 0
+}
+
+// hasVisible reports whether buf contains any character that would count
+// toward the visible length under mode.
+func hasVisible(buf []byte, mode CountMode) bool {
+    for pos := 0; pos < len(buf); {
+        tok := readToken(buf, pos)
+        if (tok.kind == textToken || tok.kind == entityToken) && mode.countsToken(tok) {
+            return true
+        }
+        pos = tok.end
+    }
+    return false
+}
+
+// countsASCII is the equivalent of truncator.counts for an ASCII character c
+// of text.
+func countsASCII(c byte, mode CountMode, whitespace bool) bool {
+    switch {
+    case whitespace && (isSpaceByte(c) || c == '\v'):
+        return true
+    case mode == AlphanumericOnly:
+        return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+    }
+    return ' ' < c && c < 0x7f
+}
+
+// isOpeningPunctuation reports whether r is punctuation that is attached to
+// the word after it, such as "(" or an opening quotation mark.
+func isOpeningPunctuation(r rune) bool {
+    return r == '"' || r == '\'' || unicode.In(r, unicode.Ps, unicode.Pi)
+}
+
+// isClosingPunctuation reports whether r is punctuation that is attached to
+// the word before it, such as ")", "." or a closing quotation mark.
+func isClosingPunctuation(r rune) bool {
+    return unicode.In(r, unicode.Pe, unicode.Pf, unicode.Po)
+}
 
-    return output, nil
+// isWordRune reports whether r is part of a word.
+func isWordRune(r rune) bool {
+    return unicode.IsLetter(r) || unicode.IsDigit(r)
 }
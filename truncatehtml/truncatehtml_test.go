@@ -1,6 +1,11 @@
 package truncatehtml
 
-import "testing"
+import (
+  "math"
+  "strings"
+  "testing"
+  "unicode"
+)
 
 // TestTruncateHtml performs some basic sanity checks of TruncateHtml.
 func TestTruncateHtml(t *testing.T) {
@@ -124,16 +129,3886 @@ func TestTruncateHtml(t *testing.T) {
       "",
       "<h1><u>1234 &copy; 1</u></h1>",
     },
+    {
+      "<p>1<span />23</p>",
+      2,
+      "",
+      "<p>1<span />2</p>",
+    },
+    {
+      "<!DOCTYPE html><p>123</p>",
+      2,
+      "",
+      "<!DOCTYPE html><p>12</p>",
+    },
   }
 
   for _, c := range cases {
     out, err := TruncateHtml([]byte(c.in), c.limit, c.ellipsis)
     got := string(out)
     if err != nil {
-      t.Errorf("Got error calling TruncateHtml(%q, 5, \"\"). Wanted: %q. Error:", c.in, c.want, err.Error())
+      t.Errorf("Got error calling TruncateHtml(%q, %d, %q). Wanted: %q. Error: %s", c.in, c.limit, c.ellipsis, c.want, err.Error())
     }
     if got != c.want {
       t.Errorf("TruncateHtml(%q, %d, %q) == %q, want %q", c.in, c.limit, c.ellipsis, got, c.want)
     }
   }
-}
\ No newline at end of file
+}
+
+// TestAlphanumericOnly checks that only letters and digits are counted when
+// CountMode is AlphanumericOnly.
+func TestAlphanumericOnly(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      want string
+  }{
+    {
+      "Hello, world! How are you?",
+      5,
+      "Hello",
+    },
+    {
+      "Hello, world! How are you?",
+      7,
+      "Hello, wo",
+    },
+    {
+      "!!!a!!!b!!!c",
+      2,
+      "!!!a!!!b",
+    },
+    {
+      "<p>--- 1, 2, 3 ---</p>",
+      2,
+      "<p>--- 1, 2</p>",
+    },
+    {
+      "<b>(a) [b] {c}</b>",
+      3,
+      "<b>(a) [b] {c</b>",
+    },
+    {
+      "a&amp;b&eacute;c",
+      3,
+      "a&amp;b&eacute;",
+    },
+    {
+      "a&mdash;&#8212;&#x2014;b",
+      2,
+      "a&mdash;&#8212;&#x2014;b",
+    },
+  }
+
+  opts := Options{CountMode: AlphanumericOnly}
+  for _, c := range cases {
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "", opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d) == %q, want %q", c.in, c.limit, got, c.want)
+    }
+  }
+}
+
+// TestEllipsisEscaping checks that the ellipsis is escaped by default and
+// copied verbatim with RawEllipsis.
+func TestEllipsisEscaping(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      ellipsis string
+      raw bool
+      want string
+  }{
+    {
+      "<p>Salt and pepper</p>",
+      4,
+      "&",
+      false,
+      "<p>Salt&amp;</p>",
+    },
+    {
+      "<p>Salt and pepper</p>",
+      4,
+      "&",
+      true,
+      "<p>Salt&</p>",
+    },
+    {
+      "<p>Salt and pepper</p>",
+      4,
+      "<b>…</b>",
+      false,
+      "<p>Salt&lt;b&gt;…&lt;/b&gt;</p>",
+    },
+    {
+      "<p>Salt and pepper</p>",
+      4,
+      "<b>…</b>",
+      true,
+      "<p>Salt<b>…</b></p>",
+    },
+    {
+      "<p>Salt and pepper</p>",
+      4,
+      "&hellip;",
+      true,
+      "<p>Salt&hellip;</p>",
+    },
+  }
+
+  for _, c := range cases {
+    opts := Options{RawEllipsis: c.raw}
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, c.ellipsis, opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, %q). Error: %s", c.in, c.limit, c.ellipsis, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, %q) with RawEllipsis=%v == %q, want %q", c.in, c.limit, c.ellipsis, c.raw, got, c.want)
+    }
+  }
+}
+
+// TestEllipsisOnlyWhenTruncated checks that input which fits within the limit
+// is returned unchanged, and that the ellipsis is only added when content is
+// dropped.
+func TestEllipsisOnlyWhenTruncated(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      only bool
+      want string
+  }{
+    {
+      "<p>Hello <b>world</b></p>",
+      1000000,
+      false,
+      "<p>Hello <b>world</b></p>...",
+    },
+    {
+      "<p>Hello <b>world</b></p>",
+      1000000,
+      true,
+      "<p>Hello <b>world</b></p>",
+    },
+    {
+      "<p>Hello <b>world</b></p>\n<p><img src=\"a.png\"></p>\n",
+      1000000,
+      true,
+      "<p>Hello <b>world</b></p>\n<p><img src=\"a.png\"></p>\n",
+    },
+    {
+      "<p>Hello <b>world</b></p>\n<p><img src=\"a.png\"></p>\n",
+      10,
+      true,
+      "<p>Hello <b>world</b></p>\n<p><img src=\"a.png\"></p>\n",
+    },
+    {
+      "<p>Hello <b>world</b></p>\n<p><img src=\"a.png\"></p>\n",
+      10,
+      false,
+      "<p>Hello <b>world...</b></p>",
+    },
+    {
+      "<p>Hello <b>world",
+      100,
+      true,
+      "<p>Hello <b>world</b></p>",
+    },
+    {
+      "<p>Hello <b>world</b></p>",
+      7,
+      true,
+      "<p>Hello <b>wo...</b></p>",
+    },
+  }
+
+  for _, c := range cases {
+    opts := Options{EllipsisOnlyWhenTruncated: c.only}
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "...", opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"...\") with EllipsisOnlyWhenTruncated=%v == %q, want %q", c.in, c.limit, c.only, got, c.want)
+    }
+  }
+}
+
+// TestWordBoundary checks that words are not cut in half in word boundary
+// mode, with and without the mid-word fallback.
+func TestWordBoundary(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      fallback bool
+      want string
+  }{
+    {
+      "Monty Python's Flying Circus",
+      8,
+      false,
+      "Monty...",
+    },
+    {
+      "Monty Python's Flying Circus",
+      5,
+      false,
+      "Monty...",
+    },
+    {
+      "<p>Monty <b>Python's Flying</b> Circus</p>",
+      15,
+      false,
+      "<p>Monty <b>Python's...</b></p>",
+    },
+    {
+      "<p>Monty <b>Python's</b> Flying Circus</p>",
+      14,
+      false,
+      "<p>Monty <b>Python's</b>...</p>",
+    },
+    {
+      "<p>Monty <b>Pyt</b>hon</p>",
+      7,
+      false,
+      "<p>Monty...</p>",
+    },
+    {
+      "Supercalifragilisticexpialidocious",
+      5,
+      false,
+      "",
+    },
+    {
+      "Supercalifragilisticexpialidocious",
+      5,
+      true,
+      "Super...",
+    },
+    {
+      "<p>Supercalifragilisticexpialidocious is long</p>",
+      5,
+      true,
+      "<p>Super...</p>",
+    },
+    {
+      "Monty, Python",
+      5,
+      false,
+      "Monty...",
+    },
+  }
+
+  for _, c := range cases {
+    opts := Options{WordBoundary: true, WordBoundaryFallbackToChar: c.fallback}
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "...", opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"...\") with WordBoundaryFallbackToChar=%v == %q, want %q", c.in, c.limit, c.fallback, got, c.want)
+    }
+  }
+}
+
+// TestWordCutStrategy checks each word cut strategy on the same input.
+func TestWordCutStrategy(t *testing.T) {
+  in := "<p>Monty <b>Python</b> Flying Circus</p>"
+  cases := []struct {
+      limit int
+      opts Options
+      want string
+  }{
+    {8, Options{}, "<p>Monty <b>Pyt...</b></p>"},
+    {8, Options{WordCut: CutAtBoundary, WordBoundary: true}, "<p>Monty...</p>"},
+    {8, Options{WordCut: CutBefore}, "<p>Monty...</p>"},
+    {8, Options{WordCut: CutAfter, WordOvershoot: 3}, "<p>Monty <b>Python...</b></p>"},
+    {8, Options{WordCut: CutAfter, WordOvershoot: 2}, "<p>Monty...</p>"},
+    {12, Options{WordCut: CutAfter, WordOvershoot: 10}, "<p>Monty <b>Python</b> Flying...</p>"},
+    {2, Options{WordCut: CutAfter, WordOvershoot: 1}, ""},
+    {2, Options{WordCut: CutAfter, WordOvershoot: 1, WordBoundaryFallbackToChar: true}, "<p>Mo...</p>"},
+    {11, Options{WordCut: CutAfter}, "<p>Monty <b>Python...</b></p>"},
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtmlWithOptions([]byte(in), c.limit, "...", c.opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"...\"). Error: %s", in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"...\") with WordCut=%d, WordOvershoot=%d == %q, want %q", in, c.limit, c.opts.WordCut, c.opts.WordOvershoot, got, c.want)
+    }
+  }
+}
+
+// TestLoneAmpersand checks that an ampersand which does not start an entity
+// counts as exactly one visible character and is copied unchanged.
+func TestLoneAmpersand(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      want string
+  }{
+    {
+      "Salt & Pepper",
+      4,
+      "Salt",
+    },
+    {
+      "Salt & Pepper",
+      5,
+      "Salt &",
+    },
+    {
+      "Salt & Pepper",
+      6,
+      "Salt & P",
+    },
+    {
+      "<b>Salt & Pepper</b>",
+      11,
+      "<b>Salt & Pepper</b>",
+    },
+    {
+      "<b>Salt & Pepper & Co</b>",
+      12,
+      "<b>Salt & Pepper &</b>",
+    },
+    {
+      "R&D &amp; Q&A",
+      5,
+      "R&D &amp; Q",
+    },
+    {
+      "a&&b",
+      3,
+      "a&&",
+    },
+    {
+      "&",
+      1,
+      "&",
+    },
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtml([]byte(c.in), c.limit, "")
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtml(%q, %d, \"\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtml(%q, %d, \"\") == %q, want %q", c.in, c.limit, got, c.want)
+    }
+  }
+}
+
+// TestComments checks that comments are copied to the output without
+// counting toward the limit, or removed entirely with StripComments.
+func TestComments(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      strip bool
+      want string
+  }{
+    {
+      "<p>12<!-- a comment -->345</p>",
+      4,
+      false,
+      "<p>12<!-- a comment -->34</p>",
+    },
+    {
+      "<p>12<!-- a comment -->345</p>",
+      4,
+      true,
+      "<p>1234</p>",
+    },
+    {
+      "<!-- wp:paragraph --><p>Hello world</p><!-- /wp:paragraph -->",
+      5,
+      false,
+      "<!-- wp:paragraph --><p>Hello</p>",
+    },
+    {
+      "<!-- wp:paragraph --><p>Hello world</p><!-- /wp:paragraph -->",
+      5,
+      true,
+      "<p>Hello</p>",
+    },
+    {
+      "<!-- wp:paragraph --><p>Hi</p><!-- /wp:paragraph -->\n<!-- wp:paragraph --><p>there</p><!-- /wp:paragraph -->",
+      100,
+      true,
+      "<p>Hi</p><p>there</p>",
+    },
+    {
+      "<p>1<!-- <b> is not a tag -->2</p>",
+      2,
+      false,
+      "<p>1<!-- <b> is not a tag -->2</p>",
+    },
+    {
+      "<p>1<!-- <b> is not a tag -->2</p>",
+      2,
+      true,
+      "<p>12</p>",
+    },
+    {
+      "<p>12<!-- never closed 345</p>",
+      5,
+      true,
+      "<p>12</p>",
+    },
+    {
+      "<p>1<!---->2<!---->3</p>",
+      2,
+      false,
+      "<p>1<!---->2</p>",
+    },
+    {
+      "<p>1<!---->2<!---->3</p>",
+      2,
+      true,
+      "<p>12</p>",
+    },
+    {
+      "<p>1<!-- a -- b -->23</p>",
+      2,
+      false,
+      "<p>1<!-- a -- b -->2</p>",
+    },
+    {
+      "<p>1<!-- a -- b -->23</p>",
+      2,
+      true,
+      "<p>12</p>",
+    },
+    {
+      "<p>1<!-- <!-- nested --> -->2</p>",
+      3,
+      false,
+      "<p>1<!-- <!-- nested --> --</p>",
+    },
+    {
+      "<p>1<!-->2<!--->3</p>",
+      3,
+      true,
+      "<p>123</p>",
+    },
+    {
+      "<p>1<!-- bang --!>2</p>",
+      2,
+      true,
+      "<p>12</p>",
+    },
+    {
+      "<!-- wp:quote {\"className\":\"x--y\"} --><blockquote>Quote</blockquote><!-- /wp:quote -->",
+      3,
+      true,
+      "<blockquote>Quo</blockquote>",
+    },
+  }
+
+  for _, c := range cases {
+    opts := Options{StripComments: c.strip}
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "", opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"\") with StripComments=%v == %q, want %q", c.in, c.limit, c.strip, got, c.want)
+    }
+  }
+}
+
+// TestDegenerateTags checks that tag-like text which is not a valid tag is
+// handled the way browsers handle it and never stalls the scanner.
+func TestDegenerateTags(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      want string
+  }{
+    {
+      "a</>bc",
+      2,
+      "a</>b",
+    },
+    {
+      "a</ b>cd",
+      2,
+      "a</ b>c",
+    },
+    {
+      "a< >bc",
+      2,
+      "a<",
+    },
+    {
+      "a< >bc",
+      4,
+      "a< >b",
+    },
+    {
+      "1 < 2 and <b>3 > 2</b>",
+      7,
+      "1 < 2 and <b>3</b>",
+    },
+    {
+      "<>",
+      5,
+      "<>",
+    },
+    {
+      "ab<",
+      5,
+      "ab<",
+    },
+    {
+      "ab</",
+      5,
+      "ab</",
+    },
+    {
+      "<</>>",
+      5,
+      "<</>>",
+    },
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtml([]byte(c.in), c.limit, "")
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtml(%q, %d, \"\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtml(%q, %d, \"\") == %q, want %q", c.in, c.limit, got, c.want)
+    }
+  }
+}
+
+// TestRenderedWhitespace checks that runs of whitespace count as a single
+// character only where a browser would render a space.
+func TestRenderedWhitespace(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      want string
+  }{
+    {
+      "<div>\n  <p>\n    Hello\n    world\n  </p>\n</div>",
+      7,
+      "<div>\n  <p>\n    Hello\n    w</p></div>",
+    },
+    {
+      "<div>\n  <p>\n    Hello\n    world\n  </p>\n</div>",
+      6,
+      "<div>\n  <p>\n    Hello\n    </p></div>",
+    },
+    {
+      "   a   b   ",
+      3,
+      "   a   b",
+    },
+    {
+      "<p>a</p>\n  <p>b</p>",
+      2,
+      "<p>a</p>\n  <p>b</p>",
+    },
+    {
+      "a <b> </b>b",
+      3,
+      "a <b> </b>b",
+    },
+    {
+      "<p>a\tb\nc</p>",
+      5,
+      "<p>a\tb\nc</p>",
+    },
+  }
+
+  opts := Options{RenderedWhitespace: true}
+  for _, c := range cases {
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "", opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"\") with RenderedWhitespace == %q, want %q", c.in, c.limit, got, c.want)
+    }
+  }
+}
+
+// TestRuby checks that ruby annotations can be kept whole or dropped, and
+// that readings can be excluded from the count.
+func TestRuby(t *testing.T) {
+  in := "<p>Read <ruby>漢<rt>かん</rt>字<rt>じ</rt></ruby> now</p>"
+  cases := []struct {
+      limit int
+      mode AtomicMode
+      skip bool
+      want string
+  }{
+    {
+      6,
+      AtomicOff,
+      false,
+      "<p>Read <ruby>漢<rt>か</rt></ruby></p>",
+    },
+    {
+      6,
+      AtomicExclude,
+      false,
+      "<p>Read </p>",
+    },
+    {
+      6,
+      AtomicInclude,
+      false,
+      "<p>Read <ruby>漢<rt>かん</rt>字<rt>じ</rt></ruby></p>",
+    },
+    {
+      9,
+      AtomicExclude,
+      false,
+      "<p>Read <ruby>漢<rt>かん</rt>字<rt>じ</rt></ruby></p>",
+    },
+    {
+      5,
+      AtomicOff,
+      true,
+      "<p>Read <ruby>漢</ruby></p>",
+    },
+    {
+      5,
+      AtomicExclude,
+      true,
+      "<p>Read </p>",
+    },
+    {
+      6,
+      AtomicExclude,
+      true,
+      "<p>Read <ruby>漢<rt>かん</rt>字<rt>じ</rt></ruby></p>",
+    },
+    {
+      7,
+      AtomicOff,
+      true,
+      "<p>Read <ruby>漢<rt>かん</rt>字<rt>じ</rt></ruby> n</p>",
+    },
+  }
+
+  for _, c := range cases {
+    opts := Options{Ruby: c.mode, SkipRubyText: c.skip}
+    out, err := TruncateHtmlWithOptions([]byte(in), c.limit, "", opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"\"). Error: %s", in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"\") with Ruby=%v, SkipRubyText=%v == %q, want %q", in, c.limit, c.mode, c.skip, got, c.want)
+    }
+  }
+}
+
+// TestLimitAtEndOfInput checks inputs whose visible length is exactly the
+// limit and which end without a trailing tag, so the last rune is neither
+// dropped nor duplicated.
+func TestLimitAtEndOfInput(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      want string
+  }{
+    {
+      "abcde",
+      5,
+      "abcde...",
+    },
+    {
+      "abcde",
+      4,
+      "abcd...",
+    },
+    {
+      "a&copy;c",
+      3,
+      "a&copy;c...",
+    },
+    {
+      "a&copy;c",
+      2,
+      "a&copy;...",
+    },
+    {
+      "ab&copy;",
+      3,
+      "ab&copy;...",
+    },
+    {
+      "&copy;",
+      1,
+      "&copy;...",
+    },
+    {
+      "abcd😄",
+      5,
+      "abcd😄...",
+    },
+    {
+      "<b>abcde",
+      5,
+      "<b>abcde...</b>",
+    },
+    {
+      "abcde ",
+      5,
+      "abcde...",
+    },
+    {
+      "a",
+      1,
+      "a...",
+    },
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtml([]byte(c.in), c.limit, "...")
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtml(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtml(%q, %d, \"...\") == %q, want %q", c.in, c.limit, got, c.want)
+    }
+  }
+}
+
+// TestMediaWeight checks that media elements count as a fixed number of
+// visible characters when MediaWeight is set.
+func TestMediaWeight(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      weight int
+      want string
+  }{
+    {
+      "<p>ab<img src=\"a.png\">cd</p>",
+      3,
+      0,
+      "<p>ab<img src=\"a.png\">c</p>",
+    },
+    {
+      "<p>ab<img src=\"a.png\">cd</p>",
+      3,
+      5,
+      "<p>ab</p>",
+    },
+    {
+      "<p>ab<img src=\"a.png\">cd</p>",
+      7,
+      5,
+      "<p>ab<img src=\"a.png\"></p>",
+    },
+    {
+      "<p>ab<img src=\"a.png\">cd</p>",
+      8,
+      5,
+      "<p>ab<img src=\"a.png\">c</p>",
+    },
+    {
+      "<img src=\"a.png\"><img src=\"b.png\"><img src=\"c.png\">",
+      25,
+      10,
+      "<img src=\"a.png\"><img src=\"b.png\">",
+    },
+    {
+      "<p>a</p><video src=\"v.mp4\"></video><p>b</p>",
+      6,
+      5,
+      "<p>a</p><video src=\"v.mp4\"></video>",
+    },
+    {
+      "<p>a</p><iframe src=\"x\"></iframe><p>b</p>",
+      5,
+      5,
+      "<p>a</p>",
+    },
+    {
+      "<p>ab<img src=\"a.png\">cd</p>",
+      3,
+      math.MaxInt,
+      "<p>ab</p>",
+    },
+  }
+
+  for _, c := range cases {
+    opts := Options{MediaWeight: c.weight}
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "", opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"\") with MediaWeight=%d == %q, want %q", c.in, c.limit, c.weight, got, c.want)
+    }
+  }
+}
+
+// TestPlainTextFastPath checks that input without markup gives the same
+// result whether or not it takes the plain text fast path.
+func TestPlainTextFastPath(t *testing.T) {
+  inputs := []string{
+    "",
+    "Hello world",
+    "  leading and trailing spaces  ",
+    "😄u n i 😄 c😄o😄d😄e",
+    "tabs\tand\nnewlines\r\n",
+    "a > b",
+    "invalid \xff utf-8",
+  }
+
+  for _, in := range inputs {
+    for limit := -1; limit <= len(in)+1; limit++ {
+      want, err := TruncateHtmlWithOptions([]byte(in), limit, "…", Options{})
+      if err != nil {
+        t.Fatalf("Got error calling TruncateHtmlWithOptions(%q, %d, \"…\"). Error: %s", in, limit, err.Error())
+      }
+      got, err := TruncateHtml([]byte(in), limit, "…")
+      if err != nil {
+        t.Fatalf("Got error calling TruncateHtml(%q, %d, \"…\"). Error: %s", in, limit, err.Error())
+      }
+      if string(got) != string(want) {
+        t.Errorf("TruncateHtml(%q, %d, \"…\") == %q, want %q", in, limit, got, want)
+      }
+    }
+  }
+}
+
+var plainText = []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 200))
+
+// BenchmarkPlainText measures TruncateHtml on input without markup, which
+// takes the fast path.
+func BenchmarkPlainText(b *testing.B) {
+  for i := 0; i < b.N; i++ {
+    TruncateHtml(plainText, 5000, "...")
+  }
+}
+
+// BenchmarkPlainTextGeneral measures the same input through the general
+// path, for comparison with BenchmarkPlainText.
+func BenchmarkPlainTextGeneral(b *testing.B) {
+  for i := 0; i < b.N; i++ {
+    TruncateHtmlWithOptions(plainText, 5000, "...", Options{})
+  }
+}
+
+// giantAttribute returns a paragraph whose start tag has a data attribute n
+// bytes long, full of characters that look like markup.
+func giantAttribute(n int) []byte {
+  value := strings.Repeat("<b a='x'>&amp;", n/14+1)[:n]
+  return []byte("<p data-x=\"" + value + "\">Hello <b>world</b></p>")
+}
+
+// TestGiantAttribute checks that a start tag with a huge attribute is copied
+// whole and the text after it is truncated as usual.
+func TestGiantAttribute(t *testing.T) {
+  for _, n := range []int{10, 1000, 100000} {
+    in := giantAttribute(n)
+    tag := string(in[:len(in)-len("Hello <b>world</b></p>")])
+    want := tag + "Hello <b>wo...</b></p>"
+    out, err := TruncateHtml(in, 7, "...")
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtml(<%d byte attribute>, 7, \"...\"). Error: %s", n, err.Error())
+    }
+    if string(out) != want {
+      t.Errorf("TruncateHtml(<%d byte attribute>, 7, \"...\") == %.80q..., want %.80q...", n, out, want)
+    }
+  }
+}
+
+// The tag end is found with a single quote-aware pass over the tag, so the
+// time per byte should stay the same as the attribute grows.
+func BenchmarkGiantAttribute10K(b *testing.B) {
+  in := giantAttribute(10000)
+  b.SetBytes(int64(len(in)))
+  for i := 0; i < b.N; i++ {
+    TruncateHtml(in, 7, "...")
+  }
+}
+
+func BenchmarkGiantAttribute100K(b *testing.B) {
+  in := giantAttribute(100000)
+  b.SetBytes(int64(len(in)))
+  for i := 0; i < b.N; i++ {
+    TruncateHtml(in, 7, "...")
+  }
+}
+
+// TestQuotedAttributes checks that angle brackets inside quoted attribute
+// values, as emitted by template engines, do not end or start a tag.
+func TestQuotedAttributes(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      want string
+  }{
+    {
+      "<div data-json='{\"a\":\"<b>\"}'>Hello world</div>",
+      5,
+      "<div data-json='{\"a\":\"<b>\"}'>Hello</div>",
+    },
+    {
+      "<div data-json=\"{&quot;a&quot;:&quot;<b>x</b>&quot;}\">Hello world</div>",
+      5,
+      "<div data-json=\"{&quot;a&quot;:&quot;<b>x</b>&quot;}\">Hello</div>",
+    },
+    {
+      "<a title=\"1 > 0\">yes</a> and no",
+      2,
+      "<a title=\"1 > 0\">ye</a>",
+    },
+    {
+      "<span data-tpl = '<i>{{name}}</i>'>text</span>",
+      3,
+      "<span data-tpl = '<i>{{name}}</i>'>tex</span>",
+    },
+    {
+      "<img alt=\"<b>\" src=\"a.png\">text",
+      2,
+      "<img alt=\"<b>\" src=\"a.png\">te",
+    },
+    {
+      "<p class=\"x'y\">it's</p>",
+      2,
+      "<p class=\"x'y\">it</p>",
+    },
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtml([]byte(c.in), c.limit, "")
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtml(%q, %d, \"\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtml(%q, %d, \"\") == %q, want %q", c.in, c.limit, got, c.want)
+    }
+  }
+}
+
+// TestTruncateHtmlRunes checks that every rune of text counts, including
+// spaces, while markup does not.
+func TestTruncateHtmlRunes(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      want string
+  }{
+    {
+      "Hello world",
+      7,
+      "Hello w",
+    },
+    {
+      "<p>Hello <b>world</b></p>",
+      6,
+      "<p>Hello </p>",
+    },
+    {
+      "<p>Hello <b>world</b></p>",
+      7,
+      "<p>Hello <b>w</b></p>",
+    },
+    {
+      "a &amp; b &copy; c",
+      5,
+      "a &amp; b",
+    },
+    {
+      "<p>Hello</p>\n<p>World</p>",
+      6,
+      "<p>Hello</p>\n",
+    },
+    {
+      "  two  spaces",
+      4,
+      "  tw",
+    },
+    {
+      "😄 u n i",
+      3,
+      "😄 u",
+    },
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtmlRunes([]byte(c.in), c.limit, "")
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlRunes(%q, %d, \"\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlRunes(%q, %d, \"\") == %q, want %q", c.in, c.limit, got, c.want)
+    }
+  }
+}
+
+// TestDropDanglingTerms checks that a definition list term is not left
+// without the start of its definition.
+func TestDropDanglingTerms(t *testing.T) {
+  in := "<dl><dt>Cat</dt><dd>Meows</dd><dt>Dog</dt><dd>Barks</dd></dl>"
+  cases := []struct {
+      limit int
+      drop bool
+      want string
+  }{
+    {
+      2,
+      false,
+      "<dl><dt>Ca</dt></dl>",
+    },
+    {
+      2,
+      true,
+      "<dl></dl>",
+    },
+    {
+      3,
+      true,
+      "<dl></dl>",
+    },
+    {
+      4,
+      true,
+      "<dl><dt>Cat</dt><dd>M</dd></dl>",
+    },
+    {
+      9,
+      false,
+      "<dl><dt>Cat</dt><dd>Meows</dd><dt>D</dt></dl>",
+    },
+    {
+      9,
+      true,
+      "<dl><dt>Cat</dt><dd>Meows</dd></dl>",
+    },
+    {
+      12,
+      true,
+      "<dl><dt>Cat</dt><dd>Meows</dd><dt>Dog</dt><dd>B</dd></dl>",
+    },
+  }
+
+  for _, c := range cases {
+    opts := Options{DropDanglingTerms: c.drop}
+    out, err := TruncateHtmlWithOptions([]byte(in), c.limit, "", opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"\"). Error: %s", in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"\") with DropDanglingTerms=%v == %q, want %q", in, c.limit, c.drop, got, c.want)
+    }
+  }
+}
+
+// TestDropDanglingTermsScope checks that a term is only dropped while its
+// own definition list is still open and has no definition for it.
+func TestDropDanglingTermsScope(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      want string
+  }{
+    {
+      "<dl><dt>A</dt></dl><p>more text here</p>",
+      6,
+      "<dl><dt>A</dt></dl><p>more t</p>",
+    },
+    {
+      "<dl><dt>A<dl><dt>B</dt><dd>C</dd></dl>D</dt><dd>E</dd></dl>",
+      4,
+      "<dl></dl>",
+    },
+  }
+
+  for _, c := range cases {
+    opts := Options{DropDanglingTerms: true}
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "", opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"\") with DropDanglingTerms == %q, want %q", c.in, c.limit, got, c.want)
+    }
+  }
+}
+
+// TestValidateEllipsis checks that a raw ellipsis with unbalanced tags is
+// rejected when ValidateEllipsis is set.
+func TestValidateEllipsis(t *testing.T) {
+  cases := []struct {
+      ellipsis string
+      validate bool
+      want string
+      err error
+  }{
+    {
+      "<b>…</b>",
+      true,
+      "<p>Hello<b>…</b></p>",
+      nil,
+    },
+    {
+      "<a href=\"/more\">more</a><br>",
+      true,
+      "<p>Hello<a href=\"/more\">more</a><br></p>",
+      nil,
+    },
+    {
+      "<b>…",
+      true,
+      "",
+      EllipsisUnbalancedError,
+    },
+    {
+      "…</b>",
+      true,
+      "",
+      EllipsisUnbalancedError,
+    },
+    {
+      "</p>",
+      true,
+      "",
+      EllipsisUnbalancedError,
+    },
+    {
+      "<b>…",
+      false,
+      "<p>Hello<b>…</p>",
+      nil,
+    },
+  }
+
+  in := "<p>Hello world</p>"
+  for _, c := range cases {
+    opts := Options{RawEllipsis: true, ValidateEllipsis: c.validate}
+    out, err := TruncateHtmlWithOptions([]byte(in), 5, c.ellipsis, opts)
+    got := string(out)
+    if err != c.err {
+      t.Errorf("TruncateHtmlWithOptions(%q, 5, %q) with ValidateEllipsis=%v returned error %v, want %v", in, c.ellipsis, c.validate, err, c.err)
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, 5, %q) with ValidateEllipsis=%v == %q, want %q", in, c.ellipsis, c.validate, got, c.want)
+    }
+  }
+}
+
+// TestIncludeTrailingVoids checks that void elements right after the cut are
+// kept when IncludeTrailingVoids is set.
+func TestIncludeTrailingVoids(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      include bool
+      want string
+  }{
+    {
+      "<p>Hello<br>world</p>",
+      5,
+      false,
+      "<p>Hello</p>",
+    },
+    {
+      "<p>Hello<br>world</p>",
+      5,
+      true,
+      "<p>Hello<br></p>",
+    },
+    {
+      "<p>Hello<br/><img src=\"a.png\">world</p>",
+      5,
+      true,
+      "<p>Hello<br/><img src=\"a.png\"></p>",
+    },
+    {
+      "<p>Hello <br>world</p>",
+      5,
+      true,
+      "<p>Hello</p>",
+    },
+    {
+      "<p>Hello<em></em><br>world</p>",
+      5,
+      true,
+      "<p>Hello</p>",
+    },
+    {
+      "<p>Hello world</p>",
+      4,
+      true,
+      "<p>Hell</p>",
+    },
+  }
+
+  for _, c := range cases {
+    opts := Options{IncludeTrailingVoids: c.include}
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "", opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"\") with IncludeTrailingVoids=%v == %q, want %q", c.in, c.limit, c.include, got, c.want)
+    }
+  }
+}
+
+// TestAppendTruncateHtml checks that AppendTruncateHtml appends to dst and
+// can reuse the same buffer across calls.
+func TestAppendTruncateHtml(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      want string
+  }{
+    {
+      "<p>Hello world</p>",
+      5,
+      "<p>Hello...</p>",
+    },
+    {
+      "",
+      5,
+      "",
+    },
+    {
+      "<b>Monty Python</b>",
+      8,
+      "<b>Monty Pyt...</b>",
+    },
+    {
+      "Plain text only",
+      5,
+      "Plain...",
+    },
+  }
+
+  dst := make([]byte, 0, 256)
+  for _, c := range cases {
+    var err error
+    dst, err = AppendTruncateHtml(dst[:0], []byte(c.in), c.limit, "...")
+    if err != nil {
+      t.Errorf("Got error calling AppendTruncateHtml(dst, %q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if string(dst) != c.want {
+      t.Errorf("AppendTruncateHtml(dst, %q, %d, \"...\") == %q, want %q", c.in, c.limit, dst, c.want)
+    }
+    if cap(dst) != 256 {
+      t.Errorf("AppendTruncateHtml(dst, %q, %d, \"...\") reallocated dst", c.in, c.limit)
+    }
+  }
+
+  prefix := []byte("Summary: ")
+  out, err := AppendTruncateHtml(prefix, []byte("<i>Hello world</i>"), 5, "")
+  if err != nil {
+    t.Fatalf("Got error calling AppendTruncateHtml(%q, \"<i>Hello world</i>\", 5, \"\"). Error: %s", prefix, err.Error())
+  }
+  if string(out) != "Summary: <i>Hello</i>" {
+    t.Errorf("AppendTruncateHtml(%q, \"<i>Hello world</i>\", 5, \"\") == %q, want %q", prefix, out, "Summary: <i>Hello</i>")
+  }
+
+  out, err = AppendTruncateHtml(prefix, []byte("<i>Hello</b>"), 10, "")
+  if err != UnbalancedTagsError || string(out) != "Summary: " {
+    t.Errorf("AppendTruncateHtml(%q, \"<i>Hello</b>\", 10, \"\") == %q, %v, want %q, UnbalancedTagsError", prefix, out, err, "Summary: ")
+  }
+}
+
+// TestCountAttrText checks that the listed attributes of void elements count
+// toward the limit.
+func TestCountAttrText(t *testing.T) {
+  in := "<p>Hi <img src=\"cat.png\" alt=\"A cat\" title=\"Cat!\" aria-label=\"Kitty\"> there</p>"
+  cases := []struct {
+      attrs []string
+      limit int
+      want string
+  }{
+    {
+      nil,
+      3,
+      "<p>Hi <img src=\"cat.png\" alt=\"A cat\" title=\"Cat!\" aria-label=\"Kitty\"> t</p>",
+    },
+    {
+      []string{"alt"},
+      3,
+      "<p>Hi </p>",
+    },
+    {
+      []string{"alt"},
+      6,
+      "<p>Hi <img src=\"cat.png\" alt=\"A cat\" title=\"Cat!\" aria-label=\"Kitty\"></p>",
+    },
+    {
+      []string{"alt", "title", "aria-label"},
+      14,
+      "<p>Hi </p>",
+    },
+    {
+      []string{"alt", "title", "aria-label"},
+      16,
+      "<p>Hi <img src=\"cat.png\" alt=\"A cat\" title=\"Cat!\" aria-label=\"Kitty\"> t</p>",
+    },
+    {
+      []string{"ALT", "src"},
+      13,
+      "<p>Hi <img src=\"cat.png\" alt=\"A cat\" title=\"Cat!\" aria-label=\"Kitty\"></p>",
+    },
+  }
+
+  for _, c := range cases {
+    opts := Options{CountAttrText: c.attrs}
+    out, err := TruncateHtmlWithOptions([]byte(in), c.limit, "", opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"\"). Error: %s", in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"\") with CountAttrText=%q == %q, want %q", in, c.limit, c.attrs, got, c.want)
+    }
+  }
+}
+
+// TestCountFormValues checks that the values of inputs shown as text count
+// toward the limit.
+func TestCountFormValues(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      want string
+  }{
+    {
+      "<p>Go <input type=\"submit\" value=\"Submit\"> now</p>",
+      8,
+      "<p>Go <input type=\"submit\" value=\"Submit\"></p>",
+    },
+    {
+      "<p>Go <input type=\"submit\" value=\"Submit\"> now</p>",
+      7,
+      "<p>Go </p>",
+    },
+    {
+      "<p>Go <input value=\"abc\"> now</p>",
+      5,
+      "<p>Go <input value=\"abc\"></p>",
+    },
+    {
+      "<p>Go <input type=\"HIDDEN\" value=\"token\"> now</p>",
+      3,
+      "<p>Go <input type=\"HIDDEN\" value=\"token\"> n</p>",
+    },
+    {
+      "<p>Go <input type=\"checkbox\" value=\"yes\"> now</p>",
+      3,
+      "<p>Go <input type=\"checkbox\" value=\"yes\"> n</p>",
+    },
+    {
+      "<p><button>Go</button> <input value=\"Stop\"></p>",
+      4,
+      "<p><button>Go</button> </p>",
+    },
+    {
+      "<p><img alt=\"ok\" value=\"x\"> now</p>",
+      1,
+      "<p><img alt=\"ok\" value=\"x\"> n</p>",
+    },
+  }
+
+  for _, c := range cases {
+    opts := Options{CountFormValues: true}
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "", opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"\") with CountFormValues == %q, want %q", c.in, c.limit, got, c.want)
+    }
+  }
+}
+
+// TestTagAttributes checks the attribute parser used for attribute counting.
+func TestTagAttributes(t *testing.T) {
+  raw := []byte("<img SRC=a.png alt = 'A &amp; B' data-x=\"1 > 0\" hidden title=\"\"/>")
+  want := []attribute{
+    {"src", "a.png"},
+    {"alt", "A & B"},
+    {"data-x", "1 > 0"},
+    {"hidden", ""},
+    {"title", ""},
+  }
+
+  got := tagAttributes(raw)
+  if len(got) != len(want) {
+    t.Fatalf("tagAttributes(%q) == %q, want %q", raw, got, want)
+  }
+  for i := range want {
+    if got[i] != want[i] {
+      t.Errorf("tagAttributes(%q)[%d] == %q, want %q", raw, i, got[i], want[i])
+    }
+  }
+}
+
+// TestDisplayWidth checks that wide characters count as two when
+// DisplayWidth is set.
+func TestDisplayWidth(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      width bool
+      want string
+  }{
+    {
+      "ab漢字cd",
+      5,
+      false,
+      "ab漢字c",
+    },
+    {
+      "ab漢字cd",
+      5,
+      true,
+      "ab漢",
+    },
+    {
+      "ab漢字cd",
+      6,
+      true,
+      "ab漢字",
+    },
+    {
+      "<p>ＡＢＣabc</p>",
+      5,
+      true,
+      "<p>ＡＢ</p>",
+    },
+    {
+      "<p>ｱｲｳｴｵ</p>",
+      5,
+      true,
+      "<p>ｱｲｳｴｵ</p>",
+    },
+    {
+      "<p>한국어 text</p>",
+      7,
+      true,
+      "<p>한국어 t</p>",
+    },
+    {
+      "a&#28450;b",
+      3,
+      true,
+      "a&#28450;",
+    },
+  }
+
+  for _, c := range cases {
+    opts := Options{DisplayWidth: c.width}
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "", opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"\") with DisplayWidth=%v == %q, want %q", c.in, c.limit, c.width, got, c.want)
+    }
+  }
+}
+
+// TestAtomicTags checks that elements listed in AtomicTags are kept whole or
+// left out.
+func TestAtomicTags(t *testing.T) {
+  figure := "<figure><img src=\"a.jpg\"><figcaption>A long caption</figcaption></figure>"
+  cases := []struct {
+      in string
+      limit int
+      tags []string
+      want string
+  }{
+    {
+      figure + "<p>Body text</p>",
+      5,
+      nil,
+      "<figure><img src=\"a.jpg\"><figcaption>A long...</figcaption></figure>",
+    },
+    {
+      figure + "<p>Body text</p>",
+      5,
+      []string{"figure"},
+      "...",
+    },
+    {
+      figure + "<p>Body text</p>",
+      15,
+      []string{"figure"},
+      figure + "<p>Bod...</p>",
+    },
+    {
+      figure + "<p>Body text</p>",
+      20,
+      []string{"figure"},
+      figure + "<p>Body text...</p>",
+    },
+    {
+      "<p>Intro</p>" + figure,
+      8,
+      []string{"FIGURE"},
+      "<p>Intro</p>...",
+    },
+    {
+      "<div><figure><figcaption>Caption</figcaption></figure></div>",
+      3,
+      []string{"figure"},
+      "<div>...</div>",
+    },
+  }
+
+  for _, c := range cases {
+    opts := Options{AtomicTags: c.tags}
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "...", opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"...\") with AtomicTags=%q == %q, want %q", c.in, c.limit, c.tags, got, c.want)
+    }
+  }
+}
+
+// TestLeadingComment checks that a comment at the very start of buf does not
+// shift where the text is cut.
+func TestLeadingComment(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      want string
+  }{
+    {
+      "<!-- c -->Hello",
+      1,
+      "<!-- c -->H...",
+    },
+    {
+      "<!-- c -->Hello",
+      3,
+      "<!-- c -->Hel...",
+    },
+    {
+      "<!-- c -->Hello",
+      5,
+      "<!-- c -->Hello...",
+    },
+    {
+      "<!---->Hello",
+      2,
+      "<!---->He...",
+    },
+    {
+      "<!-- a --><!-- b -->Hello",
+      4,
+      "<!-- a --><!-- b -->Hell...",
+    },
+    {
+      "<!-- c --><b>Hello</b>",
+      2,
+      "<!-- c --><b>He...</b>",
+    },
+    {
+      "<!--c-->&amp;x",
+      1,
+      "<!--c-->&amp;...",
+    },
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtml([]byte(c.in), c.limit, "...")
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtml(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtml(%q, %d, \"...\") == %q, want %q", c.in, c.limit, got, c.want)
+    }
+  }
+}
+
+// TestPairedComments checks that regions delimited by paired comment markers
+// are closed at the cut.
+func TestPairedComments(t *testing.T) {
+  react := [][2]string{{"$", "/$"}}
+  cases := []struct {
+      in string
+      limit int
+      pairs [][2]string
+      want string
+  }{
+    {
+      "<!--$--><p>Hello world</p><!--/$-->",
+      5,
+      nil,
+      "<!--$--><p>Hello</p>",
+    },
+    {
+      "<!--$--><p>Hello world</p><!--/$-->",
+      5,
+      react,
+      "<!--$--><p>Hello</p><!--/$-->",
+    },
+    {
+      "<div><!--$--><p>A</p><!--$--><p>Bcd</p><!--/$--><!--/$--></div>",
+      2,
+      react,
+      "<div><!--$--><p>A</p><!--$--><p>B</p><!--/$--><!--/$--></div>",
+    },
+    {
+      "<!--$--><p>Hi</p><!--/$--><p>there</p>",
+      3,
+      react,
+      "<!--$--><p>Hi</p><!--/$--><p>t</p>",
+    },
+    {
+      "<!-- $ --><p>Hello</p><!-- /$ -->",
+      2,
+      react,
+      "<!-- $ --><p>He</p><!--/$-->",
+    },
+    {
+      "<p>Stray<!--/$--> closer</p>",
+      6,
+      react,
+      "<p>Stray<!--/$--> c</p>",
+    },
+    {
+      "<!--[--><p>Hello</p><!--]--><!--$--><p>World</p><!--/$-->",
+      7,
+      [][2]string{{"$", "/$"}, {"[", "]"}},
+      "<!--[--><p>Hello</p><!--]--><!--$--><p>Wo</p><!--/$-->",
+    },
+  }
+
+  for _, c := range cases {
+    opts := Options{PairedComments: c.pairs}
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "", opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"\") with PairedComments=%q == %q, want %q", c.in, c.limit, c.pairs, got, c.want)
+    }
+  }
+
+  opts := Options{PairedComments: react}
+  if _, err := TruncateHtmlWithOptions([]byte("<div><!--$--></div><!--/$-->"), 10, "", opts); err != UnbalancedTagsError {
+    t.Errorf("TruncateHtmlWithOptions with a marker crossing an element returned error %v, want %v", err, UnbalancedTagsError)
+  }
+}
+
+// visibleText returns the text and entities of buf with all markup removed.
+func visibleText(buf []byte) string {
+  var text []byte
+  for pos := 0; pos < len(buf); {
+    tok := readToken(buf, pos)
+    if tok.kind == textToken || tok.kind == entityToken {
+      text = append(text, buf[tok.start:tok.end]...)
+    }
+    pos = tok.end
+  }
+  return string(text)
+}
+
+// TestStableAcrossLimits checks that raising the limit by one never removes
+// visible content shown at the lower limit.
+func TestStableAcrossLimits(t *testing.T) {
+  inputs := []string{
+    "Plain text with a few words.",
+    "<p>Hello <b>bold</b> and <i>italic <u>under</u></i> text.</p>",
+    "<div><p>One</p>\n<p>Two &amp; three</p></div><img src=\"x.png\"><p>Four</p>",
+    "<ul><li>a</li><li>b<br>c</li></ul><!-- comment --><p>d &lt; e</p>",
+    "<p>x<b></b>y<i> </i>z</p><p>  spaced   out  </p>",
+    "Ünïcödé <em>ťëxť</em> 漢字",
+    "<p><p>x</p></p>",
+    "<li><li>x</li></li>",
+    "<div><p>a<p>b</p></p></div>",
+  }
+
+  for _, in := range inputs {
+    total := len([]rune(visibleText([]byte(in))))
+    prev := ""
+    for n := 0; n <= total+1; n++ {
+      out, err := TruncateHtml([]byte(in), n, "")
+      if err != nil {
+        t.Errorf("Got error calling TruncateHtml(%q, %d, \"\"). Error: %s", in, n, err.Error())
+        break
+      }
+      text := visibleText(out)
+      if !strings.HasPrefix(text, prev) {
+        t.Errorf("TruncateHtml(%q, %d, \"\") shows %q, which does not extend %q shown at %d", in, n, text, prev, n-1)
+      }
+      prev = text
+    }
+  }
+}
+
+// TestZeroLimit checks how a limit of zero interacts with MinVisible and
+// RequiredOuterTag.
+func TestZeroLimit(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      opts Options
+      want string
+  }{
+    {
+      "<div class=\"post\"><p>Hello world</p></div>",
+      0,
+      Options{},
+      "",
+    },
+    {
+      "<div class=\"post\"><p>Hello world</p></div>",
+      0,
+      Options{MinVisible: 3},
+      "<div class=\"post\"><p>Hel...</p></div>",
+    },
+    {
+      "<div class=\"post\"><p>Hello world</p></div>",
+      8,
+      Options{MinVisible: 3},
+      "<div class=\"post\"><p>Hello wor...</p></div>",
+    },
+    {
+      "<div class=\"post\"><p>Hello world</p></div>",
+      0,
+      Options{RequiredOuterTag: "div"},
+      "<div class=\"post\"></div>",
+    },
+    {
+      "\n<DIV><p>Hello world</p></DIV>",
+      0,
+      Options{RequiredOuterTag: "div"},
+      "\n<DIV></DIV>",
+    },
+    {
+      "<div class=\"post\"><p>Hello world</p></div>",
+      3,
+      Options{RequiredOuterTag: "div"},
+      "<div class=\"post\"><p>Hel...</p></div>",
+    },
+    {
+      "<div class=\"post\"><p>Hello world</p></div>",
+      3,
+      Options{RequiredOuterTag: "div", WordBoundary: true},
+      "<div class=\"post\"></div>",
+    },
+    {
+      "<DIV CLASS=x><p>Hello</p></DIV>",
+      2,
+      Options{RequiredOuterTag: "div", XHTML: true},
+      "<div class=\"x\"><p>He...</p></div>",
+    },
+    {
+      "<p>Hello world</p>",
+      0,
+      Options{RequiredOuterTag: "div"},
+      "",
+    },
+    {
+      "<div class=\"post\"><p>Hello world</p></div>",
+      0,
+      Options{RequiredOuterTag: "div", MinVisible: 5},
+      "<div class=\"post\"><p>Hello...</p></div>",
+    },
+    {
+      "",
+      0,
+      Options{RequiredOuterTag: "div", MinVisible: 5},
+      "",
+    },
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "...", c.opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"...\") with %+v == %q, want %q", c.in, c.limit, c.opts, got, c.want)
+    }
+  }
+}
+
+// TestAtomicCodeBlocks checks that code blocks are kept whole or left out.
+func TestAtomicCodeBlocks(t *testing.T) {
+  code := "<pre><code>func main() {\n    fmt.Println(\"hi\")\n}\n</code></pre>"
+  cases := []struct {
+      in string
+      limit int
+      atomic bool
+      want string
+  }{
+    {
+      "<p>Example:</p>" + code + "<p>Done.</p>",
+      12,
+      false,
+      "<p>Example:</p><pre><code>func...</code></pre>",
+    },
+    {
+      "<p>Example:</p>" + code + "<p>Done.</p>",
+      12,
+      true,
+      "<p>Example:</p>...",
+    },
+    {
+      "<p>Example:</p>" + code + "<p>Done.</p>",
+      40,
+      true,
+      "<p>Example:</p>" + code + "<p>Don...</p>",
+    },
+    {
+      "<div>" + code + "</div>",
+      5,
+      true,
+      "<div>...</div>",
+    },
+    {
+      "<p>Use <code>go test</code> to run them.</p>",
+      7,
+      true,
+      "<p>Use <code>go te...</code></p>",
+    },
+  }
+
+  for _, c := range cases {
+    opts := Options{AtomicCodeBlocks: c.atomic}
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "...", opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"...\") with AtomicCodeBlocks=%v == %q, want %q", c.in, c.limit, c.atomic, got, c.want)
+    }
+  }
+}
+
+// TestTagCase checks that tag names are matched without regard to case.
+func TestTagCase(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      want string
+  }{
+    {
+      "<P>Hello <B>world</b></p>",
+      100,
+      "<P>Hello <B>world</b></p>",
+    },
+    {
+      "<P>Hello <B>world</B></P>",
+      7,
+      "<P>Hello <B>wo</B></P>",
+    },
+    {
+      "<Div>One<BR>two</DIV>",
+      5,
+      "<Div>One<BR>tw</Div>",
+    },
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtml([]byte(c.in), c.limit, "")
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtml(%q, %d, \"\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtml(%q, %d, \"\") == %q, want %q", c.in, c.limit, got, c.want)
+    }
+  }
+}
+
+// TestXHTML checks the VoidStyle, LowercaseTags and XHTML options.
+func TestXHTML(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      opts Options
+      want string
+  }{
+    {
+      "<P>One<BR>two<Img Src=\"a.png\"><hr/>three</P>",
+      100,
+      Options{XHTML: true},
+      "<p>One<br />two<img src=\"a.png\" /><hr/>three</p>",
+    },
+    {
+      "<DIV Class=\"x\"><P>Hello <B>world</B></P></DIV>",
+      7,
+      Options{XHTML: true},
+      "<div class=\"x\"><p>Hello <b>wo</b></p></div>",
+    },
+    {
+      "<p>a<br>b<br/>c<br />d<input type=\"text\" disabled>e</p>",
+      100,
+      Options{XHTML: true},
+      "<p>a<br />b<br/>c<br />d<input type=\"text\" disabled=\"disabled\" />e</p>",
+    },
+    {
+      "<p><INPUT TYPE=checkbox Checked VALUE='a \"b\"' data-x=a\"b>c</p>",
+      100,
+      Options{XHTML: true},
+      "<p><input type=\"checkbox\" checked=\"checked\" value='a \"b\"' data-x=\"a&quot;b\" />c</p>",
+    },
+    {
+      "<p>a<br>b</p>",
+      100,
+      Options{VoidStyle: VoidXHTML},
+      "<p>a<br />b</p>",
+    },
+    {
+      "<p>a<br>b<br/>c<br />d<input type=\"text\" disabled/>e</p>",
+      100,
+      Options{VoidStyle: VoidHTML5},
+      "<p>a<br>b<br>c<br>d<input type=\"text\" disabled>e</p>",
+    },
+    {
+      "<P>a<BR>b</P>",
+      100,
+      Options{LowercaseTags: true},
+      "<p>a<br>b</p>",
+    },
+    {
+      "<P>a<BR>b</P>",
+      100,
+      Options{VoidStyle: VoidXHTML},
+      "<P>a<BR />b</P>",
+    },
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "", c.opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"\") with %+v == %q, want %q", c.in, c.limit, c.opts, got, c.want)
+    }
+  }
+}
+
+// TestUppercaseVoidNormalization checks that void elements written in
+// uppercase are recognized and normalized to the configured style and case.
+func TestUppercaseVoidNormalization(t *testing.T) {
+  cases := []struct {
+      in string
+      opts Options
+      want string
+  }{
+    {
+      "<P>a<IMG SRC=\"x\">b</P>",
+      Options{},
+      "<P>a<IMG SRC=\"x\">b</P>",
+    },
+    {
+      "<P>a<IMG SRC=\"x\">b</P>",
+      Options{VoidStyle: VoidXHTML},
+      "<P>a<IMG SRC=\"x\" />b</P>",
+    },
+    {
+      "<P>a<IMG SRC=\"x\" />b</P>",
+      Options{VoidStyle: VoidHTML5},
+      "<P>a<IMG SRC=\"x\">b</P>",
+    },
+    {
+      "<P>a<IMG SRC=\"x\">b</P>",
+      Options{LowercaseTags: true},
+      "<p>a<img SRC=\"x\">b</p>",
+    },
+    {
+      "<P>a<IMG SRC=\"x\"/>b</P>",
+      Options{LowercaseTags: true, VoidStyle: VoidHTML5},
+      "<p>a<img SRC=\"x\">b</p>",
+    },
+    {
+      "<P>a<IMG SRC=\"x\">b</P>",
+      Options{XHTML: true},
+      "<p>a<img src=\"x\" />b</p>",
+    },
+    {
+      "<P>a<Img\tSRC=\"x\"\n>b</P>",
+      Options{XHTML: true},
+      "<p>a<img\tsrc=\"x\" />b</p>",
+    },
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtmlWithOptions([]byte(c.in), 100, "", c.opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, 100, \"\"). Error: %s", c.in, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, 100, \"\") with %+v == %q, want %q", c.in, c.opts, got, c.want)
+    }
+  }
+}
+
+// TestHugeLimit checks that a limit of math.MaxInt keeps the whole input.
+func TestHugeLimit(t *testing.T) {
+  inputs := []string{
+    "Plain text",
+    "<p>Hello <b>world</b> &amp; more</p><img src=\"x.png\">",
+    "<div><p>One</p><p>Two</p></div><!-- end -->",
+  }
+
+  for _, in := range inputs {
+    out, err := TruncateHtml([]byte(in), math.MaxInt, "...")
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtml(%q, math.MaxInt, \"...\"). Error: %s", in, err.Error())
+    }
+    if got, want := string(out), in + "..."; got != want {
+      t.Errorf("TruncateHtml(%q, math.MaxInt, \"...\") == %q, want %q", in, got, want)
+    }
+
+    opts := Options{EllipsisOnlyWhenTruncated: true, MinVisible: math.MaxInt}
+    out, err = TruncateHtmlWithOptions([]byte(in), math.MaxInt, "...", opts)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, math.MaxInt, \"...\"). Error: %s", in, err.Error())
+    }
+    if got := string(out); got != in {
+      t.Errorf("TruncateHtmlWithOptions(%q, math.MaxInt, \"...\") with EllipsisOnlyWhenTruncated == %q, want %q", in, got, in)
+    }
+
+    if truncated, err := WouldTruncate([]byte(in), math.MaxInt); err != nil || truncated {
+      t.Errorf("WouldTruncate(%q, math.MaxInt) == %v, %v, want false, nil", in, truncated, err)
+    }
+
+    out, err = TruncateHtmlWrapped([]byte(in), math.MaxInt, math.MaxInt, "...")
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWrapped(%q, math.MaxInt, math.MaxInt, \"...\"). Error: %s", in, err.Error())
+    }
+    if got, want := string(out), in + "..."; got != want {
+      t.Errorf("TruncateHtmlWrapped(%q, math.MaxInt, math.MaxInt, \"...\") == %q, want %q", in, got, want)
+    }
+  }
+}
+
+// TestStripTags checks that listed tags are removed while their text is kept.
+func TestStripTags(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      strip map[string]bool
+      want string
+  }{
+    {
+      "<p>Hello<img src=\"a.png\"> world</p><hr><p>More</p>",
+      100,
+      map[string]bool{"img": true, "hr": true},
+      "<p>Hello world</p><p>More</p>",
+    },
+    {
+      "<p>Hello<IMG SRC=\"a.png\"/> world</p><hr /><p>More</p>",
+      12,
+      map[string]bool{"img": true, "hr": true},
+      "<p>Hello world</p><p>Mo</p>",
+    },
+    {
+      "<p>Some <span class=\"x\">styled <b>bold</b> text</span> here</p>",
+      12,
+      map[string]bool{"span": true},
+      "<p>Some styled <b>bo</b></p>",
+    },
+    {
+      "<div><p>Keep <font color=\"red\">red</font></p></div>",
+      100,
+      map[string]bool{"font": true, "div": true},
+      "<p>Keep red</p>",
+    },
+    {
+      "<p>Hello<img src=\"a.png\"> world</p>",
+      100,
+      nil,
+      "<p>Hello<img src=\"a.png\"> world</p>",
+    },
+  }
+
+  for _, c := range cases {
+    opts := Options{StripTags: c.strip}
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "", opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"\") with StripTags=%v == %q, want %q", c.in, c.limit, c.strip, got, c.want)
+    }
+  }
+
+  // Stripped images don't count toward MediaWeight.
+  opts := Options{StripTags: map[string]bool{"img": true}, MediaWeight: 5}
+  out, err := TruncateHtmlWithOptions([]byte("<p>ab<img src=\"a.png\">cd</p>"), 3, "", opts)
+  if err != nil || string(out) != "<p>abc</p>" {
+    t.Errorf("TruncateHtmlWithOptions with a stripped weighted image == %q, %v, want %q, nil", out, err, "<p>abc</p>")
+  }
+}
+
+// TestOnUnbalanced checks each Action on misnested input.
+func TestOnUnbalanced(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      action Action
+      want string
+  }{
+    {
+      "<p><b>bold <i>both</b> italic</i></p>",
+      100,
+      DropCloseTag,
+      "<p><b>bold <i>both italic</i></b></p>",
+    },
+    {
+      "<p><b>bold <i>both</b> italic</i></p>",
+      100,
+      PopToMatch,
+      "<p><b>bold <i>both</i></b> italic</p>",
+    },
+    {
+      "<p><b>bold <i>both</b> italic</i></p>",
+      9,
+      PopToMatch,
+      "<p><b>bold <i>both</i></b> i</p>",
+    },
+    {
+      "<p>stray</span> end</p>",
+      100,
+      PopToMatch,
+      "<p>stray end</p>",
+    },
+    {
+      "<p>stray</span> end</p>",
+      100,
+      DropCloseTag,
+      "<p>stray end</p>",
+    },
+    {
+      "<div><span>one<span>two</div>",
+      100,
+      PopToMatch,
+      "<div><span>one<span>two</span></span></div>",
+    },
+    {
+      "<div><p>one<p>two</div>",
+      100,
+      PopToMatch,
+      "<div><p>one<p>two</div>",
+    },
+  }
+
+  for _, c := range cases {
+    action := c.action
+    opts := Options{OnUnbalanced: func(expected, found string, stack []string) Action {
+      return action
+    }}
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "", opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"\") with action %d == %q, want %q", c.in, c.limit, c.action, got, c.want)
+    }
+  }
+
+  // Fail keeps the default behavior, and the callback sees the open elements.
+  var calls []string
+  opts := Options{OnUnbalanced: func(expected, found string, stack []string) Action {
+    calls = append(calls, expected + " " + found + " " + strings.Join(stack, ","))
+    return Fail
+  }}
+  _, err := TruncateHtmlWithOptions([]byte("<p><b>x</i></b></p>"), 100, "", opts)
+  if err != UnbalancedTagsError {
+    t.Errorf("TruncateHtmlWithOptions with Fail returned error %v, want %v", err, UnbalancedTagsError)
+  }
+  if len(calls) != 1 || calls[0] != "b i p,b" {
+    t.Errorf("OnUnbalanced called with %q, want %q", calls, []string{"b i p,b"})
+  }
+}
+
+// TestNamespacedTags checks tag names with colons, periods and hyphens.
+func TestNamespacedTags(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      want string
+  }{
+    {
+      "<p class=MsoNormal>Hello<o:p></o:p></p><p class=MsoNormal>World<o:p></o:p></p>",
+      100,
+      "<p class=MsoNormal>Hello<o:p></o:p></p><p class=MsoNormal>World<o:p></o:p></p>",
+    },
+    {
+      "<p class=MsoNormal><o:p>Hello world</o:p></p>",
+      7,
+      "<p class=MsoNormal><o:p>Hello wo</o:p></p>",
+    },
+    {
+      "<div><fb:like href=\"/x\">Like this page</fb:like></div>",
+      4,
+      "<div><fb:like href=\"/x\">Like</fb:like></div>",
+    },
+    {
+      "<my-widget data-x=\"1\"><v.item>Some text</v.item></my-widget>",
+      6,
+      "<my-widget data-x=\"1\"><v.item>Some te</v.item></my-widget>",
+    },
+    {
+      "<w:Sdt_Part>Text here</w:Sdt_Part>",
+      3,
+      "<w:Sdt_Part>Tex</w:Sdt_Part>",
+    },
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtml([]byte(c.in), c.limit, "")
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtml(%q, %d, \"\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtml(%q, %d, \"\") == %q, want %q", c.in, c.limit, got, c.want)
+    }
+  }
+}
+
+// TestTruncateHtmlWords checks that words are not cut and that <wbr> is a
+// break point.
+func TestTruncateHtmlWords(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      strip bool
+      want string
+  }{
+    {
+      "<p>Hello wonderful world</p>",
+      12,
+      false,
+      "<p>Hello...</p>",
+    },
+    {
+      "<p>Super<wbr>cali<wbr>fragilistic</p>",
+      12,
+      false,
+      "<p>Super<wbr>cali...</p>",
+    },
+    {
+      "<p>Super<wbr>cali<wbr>fragilistic</p>",
+      9,
+      false,
+      "<p>Super<wbr>cali...</p>",
+    },
+    {
+      "<p>Super<wbr>cali<wbr>fragilistic</p>",
+      7,
+      false,
+      "<p>Super...</p>",
+    },
+    {
+      "<p>Super<wbr>cali<wbr>fragilistic</p>",
+      12,
+      true,
+      "<p>Supercali...</p>",
+    },
+    {
+      "<p>Supercalifragilistic</p>",
+      7,
+      false,
+      "<p>Superca...</p>",
+    },
+    {
+      "<p>Go to example.com/<wbr>some/<wbr>long/<wbr>path now</p>",
+      22,
+      false,
+      "<p>Go to example.com/<wbr>some/...</p>",
+    },
+  }
+
+  for _, c := range cases {
+    var out []byte
+    var err error
+    if c.strip {
+      opts := Options{WordBoundary: true, StripTags: map[string]bool{"wbr": true}}
+      out, err = TruncateHtmlWithOptions([]byte(c.in), c.limit, "...", opts)
+    } else {
+      out, err = TruncateHtmlWords([]byte(c.in), c.limit, "...")
+    }
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWords(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWords(%q, %d, \"...\") with strip=%v == %q, want %q", c.in, c.limit, c.strip, got, c.want)
+    }
+  }
+}
+
+// TestMixedVoidNotation checks that void elements are handled the same with
+// or without the closing slash.
+func TestMixedVoidNotation(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      style VoidStyle
+      want string
+  }{
+    {
+      "<p>a<br>b<br/>c<br />d<br class=\"x\"/>e</p>",
+      100,
+      VoidAsIs,
+      "<p>a<br>b<br/>c<br />d<br class=\"x\"/>e</p>",
+    },
+    {
+      "<p>a<br>b<br/>c<br />d<br class=\"x\"/>e</p>",
+      3,
+      VoidAsIs,
+      "<p>a<br>b<br/>c</p>",
+    },
+    {
+      "<div><br/><p>a<br>b</p><br class=\"x\"/></div>",
+      1,
+      VoidAsIs,
+      "<div><br/><p>a</p></div>",
+    },
+    {
+      "<p>a<br>b<br/>c<br />d<br class=\"x\"/>e</p>",
+      100,
+      VoidHTML5,
+      "<p>a<br>b<br>c<br>d<br class=\"x\">e</p>",
+    },
+    {
+      "<p>a<br>b<br/>c<br />d<br class=\"x\"/>e</p>",
+      100,
+      VoidXHTML,
+      "<p>a<br />b<br/>c<br />d<br class=\"x\"/>e</p>",
+    },
+    {
+      "<p>a<br>b<br class=\"x\">c</p>",
+      2,
+      VoidXHTML,
+      "<p>a<br />b</p>",
+    },
+  }
+
+  for _, c := range cases {
+    opts := Options{VoidStyle: c.style}
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "", opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"\") with VoidStyle=%d == %q, want %q", c.in, c.limit, c.style, got, c.want)
+    }
+  }
+}
+
+// TestInterBlockWhitespace checks whether whitespace between block-level tags
+// is kept when the output is rebuilt.
+func TestInterBlockWhitespace(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      opts Options
+      want string
+  }{
+    {
+      "<p>One</p>\n<p>Two</p>",
+      100,
+      Options{},
+      "<p>One</p>\n<p>Two</p>",
+    },
+    {
+      "<p>One</p>\n<!-- c -->\n<p>Two</p>",
+      100,
+      Options{StripComments: true},
+      "<p>One</p><p>Two</p>",
+    },
+    {
+      "<p>One</p>\n<!-- c -->\n<p>Two</p>",
+      100,
+      Options{StripComments: true, KeepInterBlockWhitespace: true},
+      "<p>One</p>\n\n<p>Two</p>",
+    },
+    {
+      "<ul>\n  <li>One</li>\n  <li>Two<img src=\"x.png\"></li>\n</ul>",
+      100,
+      Options{StripTags: map[string]bool{"img": true}},
+      "<ul><li>One</li><li>Two</li></ul>",
+    },
+    {
+      "<ul>\n  <li>One</li>\n  <li>Two</li>\n</ul>",
+      4,
+      Options{StripTags: map[string]bool{"img": true}},
+      "<ul><li>One</li><li>T</li></ul>",
+    },
+    {
+      "<ul>\n  <li>One</li>\n  <li>Two</li>\n</ul>",
+      100,
+      Options{StripTags: map[string]bool{"img": true}, KeepInterBlockWhitespace: true},
+      "<ul>\n  <li>One</li>\n  <li>Two</li>\n</ul>",
+    },
+    {
+      "<p>One</p> <b>bold</b> <p>Two</p>",
+      100,
+      Options{StripComments: true},
+      "<p>One</p> <b>bold</b> <p>Two</p>",
+    },
+    {
+      "<div><span>a</span> <img src=\"x.png\"> <span>b</span></div>",
+      100,
+      Options{StripTags: map[string]bool{"img": true}},
+      "<div><span>a</span>  <span>b</span></div>",
+    },
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "", c.opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"\") with %+v == %q, want %q", c.in, c.limit, c.opts, got, c.want)
+    }
+  }
+}
+
+// TestEntityBetweenText checks that the characters around an entity are
+// each counted once.
+func TestEntityBetweenText(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      want string
+  }{
+    {
+      "a&amp;b",
+      1,
+      "a",
+    },
+    {
+      "a&amp;b",
+      2,
+      "a&amp;",
+    },
+    {
+      "a&amp;bc",
+      3,
+      "a&amp;b",
+    },
+    {
+      "a&amp;bc",
+      4,
+      "a&amp;bc",
+    },
+    {
+      "<p>x&lt;y&gt;z</p>",
+      3,
+      "<p>x&lt;y</p>",
+    },
+    {
+      "<p>x&lt;y&gt;z</p>",
+      4,
+      "<p>x&lt;y&gt;</p>",
+    },
+    {
+      "<p>Tom&#39;s &amp; Jerry&#x27;s</p>",
+      6,
+      "<p>Tom&#39;s &amp;</p>",
+    },
+    {
+      "<p>Tom&#39;s &amp; Jerry&#x27;s</p>",
+      12,
+      "<p>Tom&#39;s &amp; Jerry&#x27;</p>",
+    },
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtml([]byte(c.in), c.limit, "")
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtml(%q, %d, \"\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtml(%q, %d, \"\") == %q, want %q", c.in, c.limit, got, c.want)
+    }
+
+    // Each visible character is counted exactly once.
+    if n, _ := VisibleLengthUpTo(out, math.MaxInt); n > c.limit {
+      t.Errorf("TruncateHtml(%q, %d, \"\") kept %d visible characters", c.in, c.limit, n)
+    }
+  }
+}
+
+// TestASCIIOnly checks that ASCIIOnly gives the same output as the general
+// path, including when the input is not ASCII after all.
+func TestASCIIOnly(t *testing.T) {
+  inputs := []string{
+    "<p>Hello <b>world</b> &amp; all\tthe\nrest.</p><!-- c --><p>More [text] here!</p>",
+    "Plain ASCII text, with punctuation: ~`|{}",
+    "<p>Café naïve   ​ résumé 漢字</p>",
+    "a\x00b\x7fc\vd e",
+  }
+  modes := []Options{
+    {},
+    {CountMode: AlphanumericOnly},
+    {CountWhitespace: true},
+    {WordBoundary: true},
+  }
+
+  for _, in := range inputs {
+    for _, opts := range modes {
+      for limit := 0; limit <= len(in); limit++ {
+        want, _ := TruncateHtmlWithOptions([]byte(in), limit, "...", opts)
+        fast := opts
+        fast.ASCIIOnly = true
+        got, err := TruncateHtmlWithOptions([]byte(in), limit, "...", fast)
+        if err != nil {
+          t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"...\"). Error: %s", in, limit, err.Error())
+        }
+        if string(got) != string(want) {
+          t.Errorf("TruncateHtmlWithOptions(%q, %d, \"...\") with %+v == %q, want %q", in, limit, fast, got, want)
+        }
+      }
+    }
+  }
+}
+
+var asciiHtml = []byte(strings.Repeat("<p>The quick <b>brown</b> fox jumps over the lazy dog.</p>\n", 100))
+
+// BenchmarkASCIIOnly measures the ASCIIOnly option on ASCII input.
+func BenchmarkASCIIOnly(b *testing.B) {
+  for i := 0; i < b.N; i++ {
+    TruncateHtmlWithOptions(asciiHtml, 3000, "...", Options{ASCIIOnly: true})
+  }
+}
+
+// BenchmarkASCIIGeneral measures the same input through the general path,
+// for comparison with BenchmarkASCIIOnly.
+func BenchmarkASCIIGeneral(b *testing.B) {
+  for i := 0; i < b.N; i++ {
+    TruncateHtmlWithOptions(asciiHtml, 3000, "...", Options{})
+  }
+}
+
+// TestSentenceBoundary checks that output ends after a complete sentence,
+// with default and custom terminators.
+func TestSentenceBoundary(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      terminators []rune
+      want string
+  }{
+    {
+      "<p>First sentence. Second sentence here.</p>",
+      20,
+      nil,
+      "<p>First sentence....</p>",
+    },
+    {
+      "<p>First sentence. Second sentence here.</p>",
+      14,
+      nil,
+      "<p>First sentence....</p>",
+    },
+    {
+      "<p>Really? Yes! It costs 3.14 now.</p>",
+      16,
+      nil,
+      "<p>Really? Yes!...</p>",
+    },
+    {
+      "<p>One.</p><p>Two three four.</p>",
+      8,
+      nil,
+      "<p>One....</p>",
+    },
+    {
+      "<p>No terminator in sight here</p>",
+      8,
+      nil,
+      "<p>No termin...</p>",
+    },
+    {
+      "<p>これは文です。次の文です。</p>",
+      10,
+      []rune{'。'},
+      "<p>これは文です。...</p>",
+    },
+    {
+      "<p>هل أنت بخير؟ نعم أنا بخير</p>",
+      12,
+      []rune{'؟', '.'},
+      "<p>هل أنت بخير؟...</p>",
+    },
+    {
+      "<p>First sentence. Second sentence here.</p>",
+      20,
+      []rune{'!'},
+      "<p>First sentence. Second...</p>",
+    },
+  }
+
+  for _, c := range cases {
+    opts := Options{SentenceBoundary: true, SentenceTerminators: c.terminators}
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "...", opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"...\") with SentenceTerminators=%q == %q, want %q", c.in, c.limit, c.terminators, got, c.want)
+    }
+  }
+}
+
+// TestOnlyComment checks input made up of nothing but a comment.
+func TestOnlyComment(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      strip bool
+      want string
+  }{
+    {
+      "<!-- only a comment -->",
+      10,
+      false,
+      "<!-- only a comment -->...",
+    },
+    {
+      "<!-- only a comment -->",
+      1,
+      false,
+      "<!-- only a comment -->...",
+    },
+    {
+      "<!-- only a comment -->",
+      10,
+      true,
+      "...",
+    },
+    {
+      "<!---->",
+      10,
+      false,
+      "<!---->...",
+    },
+    {
+      "<!-- never closed",
+      10,
+      false,
+      "<!-- never closed...",
+    },
+    {
+      "<!-- never closed",
+      10,
+      true,
+      "...",
+    },
+  }
+
+  for _, c := range cases {
+    opts := Options{StripComments: c.strip}
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "...", opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"...\") with StripComments=%v == %q, want %q", c.in, c.limit, c.strip, got, c.want)
+    }
+
+    // Nothing is dropped, so the only-when-truncated ellipsis is left off.
+    opts.EllipsisOnlyWhenTruncated = true
+    out, _ = TruncateHtmlWithOptions([]byte(c.in), c.limit, "...", opts)
+    if want := strings.TrimSuffix(c.want, "..."); string(out) != want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"...\") with EllipsisOnlyWhenTruncated == %q, want %q", c.in, c.limit, out, want)
+    }
+  }
+}
+
+// TestNoBreakWords checks that word boundary truncation does not break at
+// &nbsp; or inside <nobr>.
+func TestNoBreakWords(t *testing.T) {
+  cases := []struct {
+      in string
+      limit int
+      want string
+  }{
+    {
+      "<p>Visit New&nbsp;York today</p>",
+      8,
+      "<p>Visit...</p>",
+    },
+    {
+      "<p>Visit New&nbsp;York today</p>",
+      9,
+      "<p>Visit...</p>",
+    },
+    {
+      "<p>Visit New&nbsp;York today</p>",
+      13,
+      "<p>Visit New&nbsp;York...</p>",
+    },
+    {
+      "<p>Visit New York today</p>",
+      8,
+      "<p>Visit...</p>",
+    },
+    {
+      "<p>Call <nobr>555 123 4567</nobr> now</p>",
+      9,
+      "<p>Call...</p>",
+    },
+    {
+      "<p>Call <nobr>555 123 4567</nobr> now</p>",
+      14,
+      "<p>Call <nobr>555 123 4567...</nobr></p>",
+    },
+    {
+      "<p>Call <nobr>555 123 4567</nobr> now</p>",
+      15,
+      "<p>Call <nobr>555 123 4567</nobr>...</p>",
+    },
+    {
+      "<p>Call now please</p>",
+      8,
+      "<p>Call now...</p>",
+    },
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "...", Options{WordBoundary: true})
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"...\") with WordBoundary == %q, want %q", c.in, c.limit, got, c.want)
+    }
+  }
+}
+
+// TestCommentAtLimit checks that a comment next to the cut is copied whole or
+// dropped whole, never cut in the middle.
+func TestCommentAtLimit(t *testing.T) {
+  long := "<!-- " + strings.Repeat("a long comment with <b> markup & text ", 20) + "-->"
+  cases := []struct {
+      in string
+      limit int
+      opts Options
+      want string
+  }{
+    {
+      "<p>abc" + long + "def</p>",
+      3,
+      Options{},
+      "<p>abc</p>",
+    },
+    {
+      "<p>abc" + long + "def</p>",
+      4,
+      Options{},
+      "<p>abc" + long + "d</p>",
+    },
+    {
+      "<p>abc" + long + "def</p>",
+      4,
+      Options{StripComments: true},
+      "<p>abcd</p>",
+    },
+    {
+      "<p>abc" + long + "</p>",
+      3,
+      Options{EllipsisOnlyWhenTruncated: true},
+      "<p>abc" + long + "</p>",
+    },
+    {
+      "<p>abc" + long + "</p>",
+      3,
+      Options{EllipsisOnlyWhenTruncated: true, StripComments: true},
+      "<p>abc</p>",
+    },
+    {
+      "<p>abc" + long + "def</p>",
+      3,
+      Options{EllipsisOnlyWhenTruncated: true},
+      "<p>abc</p>",
+    },
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "", c.opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"\") with %+v == %q, want %q", c.in, c.limit, c.opts, got, c.want)
+    }
+  }
+}
+
+func TestMaxOutputBytes(t *testing.T) {
+  cases := []struct {
+    in       string
+    limit    int
+    ellipsis string
+    opts     Options
+    want     string
+  }{
+    {
+      "<p>Hello world</p>",
+      100,
+      "...",
+      Options{MaxOutputBytes: 16},
+      "<p>Hello ...</p>",
+    },
+    {
+      "<p>Hello world</p>",
+      3,
+      "...",
+      Options{MaxOutputBytes: 100},
+      "<p>Hel...</p>",
+    },
+    {
+      "<p>Hello</p>",
+      100,
+      "……",
+      Options{MaxOutputBytes: 10},
+      "<p>…</p>",
+    },
+    {
+      "<p>Hello</p>",
+      100,
+      "&&",
+      Options{MaxOutputBytes: 12},
+      "<p>&amp;</p>",
+    },
+    {
+      "<p>Hello</p>",
+      100,
+      "<b>…</b>",
+      Options{MaxOutputBytes: 10, RawEllipsis: true},
+      "<p></p>",
+    },
+    {
+      "<p><b>Hi</b> there</p>",
+      100,
+      "",
+      Options{MaxOutputBytes: 16},
+      "<p><b>Hi</b></p>",
+    },
+    {
+      "<p>Hi</p>",
+      100,
+      "...",
+      Options{MaxOutputBytes: 3},
+      "...",
+    },
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, c.ellipsis, c.opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, %q). Error: %s", c.in, c.limit, c.ellipsis, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, %q) with %+v == %q, want %q", c.in, c.limit, c.ellipsis, c.opts, got, c.want)
+    }
+    if len(got) > c.opts.MaxOutputBytes {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, %q) with %+v is %d bytes, over the cap", c.in, c.limit, c.ellipsis, c.opts, len(got))
+    }
+  }
+}
+
+// TestNoAutoClose checks that no closing tags are added for open elements.
+func TestNoAutoClose(t *testing.T) {
+  cases := []struct {
+    in    string
+    limit int
+    opts  Options
+    want  string
+  }{
+    {
+      "<p>Hello <b>world</b></p>",
+      8,
+      Options{NoAutoClose: true},
+      "<p>Hello <b>wor...",
+    },
+    {
+      "<p>Hello <b>world</b></p>",
+      100,
+      Options{NoAutoClose: true},
+      "<p>Hello <b>world</b></p>...",
+    },
+    {
+      "<div><p>Hi <!-- a -->there</p></div>",
+      3,
+      Options{NoAutoClose: true, PairedComments: [][2]string{{"<!-- a -->", "<!-- /a -->"}}},
+      "<div><p>Hi <!-- a -->t...",
+    },
+    {
+      "<div>Hello</div>",
+      0,
+      Options{NoAutoClose: true, RequiredOuterTag: "div"},
+      "<div>",
+    },
+    {
+      "<p>Hello <b>world</b></p>",
+      100,
+      Options{NoAutoClose: true, MaxOutputBytes: 15},
+      "<p>Hello <b>...",
+    },
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "...", c.opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"...\") with %+v == %q, want %q", c.in, c.limit, c.opts, got, c.want)
+    }
+  }
+}
+
+// TestZeroWidth checks that zero-width characters don't count, whether they
+// are written as text or as entities.
+func TestZeroWidth(t *testing.T) {
+  cases := []struct {
+    in    string
+    limit int
+    want  string
+  }{
+    {"<p>a&zwj;b&zwnj;c</p>", 3, "<p>a&zwj;b&zwnj;c</p>"},
+    {"<p>a&zwj;b&zwnj;cd</p>", 2, "<p>a&zwj;b</p>"},
+    {"<p>a‍b‌c</p>", 3, "<p>a‍b‌c</p>"},
+    {"<p>👩&#x200D;💻 and 👩‍🔬</p>", 7, "<p>👩&#x200D;💻 and 👩‍🔬</p>"},
+    {"<p>👩&#8205;💻 and 👩‍🔬</p>", 6, "<p>👩&#8205;💻 and 👩</p>"},
+    {"<p>x&#8203;y&NoBreak;z</p>", 2, "<p>x&#8203;y</p>"},
+    {"<p>x&#8203;y&NoBreak;z</p>", 3, "<p>x&#8203;y&NoBreak;z</p>"},
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtml([]byte(c.in), c.limit, "")
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtml(%q, %d, \"\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtml(%q, %d, \"\") == %q, want %q", c.in, c.limit, got, c.want)
+    }
+  }
+}
+
+// TestStripControlChars checks that control characters other than whitespace
+// are dropped from the output.
+func TestStripControlChars(t *testing.T) {
+  cases := []struct {
+    in    string
+    limit int
+    opts  Options
+    want  string
+  }{
+    {"<p>a\x00b\x07c</p>", 100, Options{}, "<p>a\x00b\x07c</p>"},
+    {"<p>a\x00b\x07c</p>", 100, Options{StripControlChars: true}, "<p>abc</p>"},
+    {"<p>a\x00b\x07c</p>", 2, Options{StripControlChars: true}, "<p>ab</p>"},
+    {"<p>a\tb\nc\r\nd\x1be\u0085f\x7f</p>", 100, Options{StripControlChars: true}, "<p>a\tb\nc\r\ndef</p>"},
+    {"<p title=\"x\x01\">a&#1;b</p>", 100, Options{StripControlChars: true}, "<p title=\"x\x01\">a&#1;b</p>"},
+    {"a\x00b c", 100, Options{StripControlChars: true, ASCIIOnly: true}, "ab c"},
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "", c.opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"\") with %+v == %q, want %q", c.in, c.limit, c.opts, got, c.want)
+    }
+  }
+}
+
+// TestSanitizeInvalidUTF8 checks that bytes that aren't valid UTF-8 are
+// replaced with U+FFFD.
+func TestSanitizeInvalidUTF8(t *testing.T) {
+  cases := []struct {
+    in    string
+    limit int
+    opts  Options
+    want  string
+  }{
+    {"<p>caf\xe9 ol\xe9</p>", 100, Options{}, "<p>caf\xe9 ol\xe9</p>..."},
+    {"<p>caf\xe9 ol\xe9</p>", 100, Options{SanitizeInvalidUTF8: true}, "<p>caf� ol�</p>..."},
+    {"<p>caf\xe9 ol\xe9</p>", 4, Options{SanitizeInvalidUTF8: true}, "<p>caf�...</p>"},
+    {"<p>\xe9\xe8 café</p>", 3, Options{SanitizeInvalidUTF8: true}, "<p>�� c...</p>"},
+    {"<p title=\"\xff\">na\xefve</p>", 3, Options{SanitizeInvalidUTF8: true}, "<p title=\"�\">na�...</p>"},
+    {"<p>caf\xe9</p>", 100, Options{SanitizeInvalidUTF8: true, ASCIIOnly: true}, "<p>caf�</p>..."},
+    {"<p>\xe2\x82 ok</p>", 100, Options{SanitizeInvalidUTF8: true}, "<p>�� ok</p>..."},
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "...", c.opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"...\") with %+v == %q, want %q", c.in, c.limit, c.opts, got, c.want)
+    }
+  }
+}
+
+// TestNestedSameName checks the stack accounting when the input closes some,
+// but not all, of several nested elements with the same name.
+func TestNestedSameName(t *testing.T) {
+  cases := []struct {
+    in    string
+    limit int
+    want  string
+  }{
+    {"<div><div>x</div>yz</div>", 1, "<div><div>x</div></div>"},
+    {"<div><div>x</div>yz</div>", 2, "<div><div>x</div>y</div>"},
+    {"<div><div>x</div></div>yz", 1, "<div><div>x</div></div>"},
+    {"<div><div><div>x</div>y</div>z</div>", 2, "<div><div><div>x</div>y</div></div>"},
+    {"<b><b><b>a</b>b</b>c</b>", 2, "<b><b><b>a</b>b</b></b>"},
+    {"<ul><li><ul><li>a</li></ul>b</li><li>c</li></ul>", 2, "<ul><li><ul><li>a</li></ul>b</li></ul>"},
+    {"<ul><li><ul><li>a</li></ul>b</li><li>c</li></ul>", 1, "<ul><li><ul><li>a</li></ul></li></ul>"},
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtml([]byte(c.in), c.limit, "")
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtml(%q, %d, \"\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtml(%q, %d, \"\") == %q, want %q", c.in, c.limit, got, c.want)
+    }
+  }
+
+  // A same-name end tag that closes more than was opened is still an error.
+  if _, err := TruncateHtml([]byte("<div><div>x</div></div></div>"), 10, ""); err != UnbalancedTagsError {
+    t.Errorf("TruncateHtml with an extra </div> returned error %v, want %v", err, UnbalancedTagsError)
+  }
+}
+
+// TestMaxImages checks that the output ends after the given number of images.
+func TestMaxImages(t *testing.T) {
+  cases := []struct {
+    in        string
+    limit     int
+    maxImages int
+    want      string
+  }{
+    {
+      "<p>a<img src=\"1\">b<img src=\"2\">c<img src=\"3\">d</p>",
+      100,
+      2,
+      "<p>a<img src=\"1\">b<img src=\"2\">...</p>",
+    },
+    {
+      "<p>a<img src=\"1\">b<img src=\"2\">c<img src=\"3\">d</p>",
+      100,
+      0,
+      "<p>a<img src=\"1\">b<img src=\"2\">c<img src=\"3\">d</p>...",
+    },
+    {
+      "<p>a<img src=\"1\">b<img src=\"2\">c<img src=\"3\">d</p>",
+      1,
+      2,
+      "<p>a...</p>",
+    },
+    {
+      "<div><p><img src=\"1\"/></p><p><img src=\"2\"/></p><p>text</p></div>",
+      100,
+      1,
+      "<div><p><img src=\"1\"/>...</p></div>",
+    },
+    {
+      "<div><picture><source srcset=\"1.webp\"><img src=\"1.png\"></picture>x<img src=\"2\">y<picture><img src=\"3\"></picture></div>",
+      100,
+      2,
+      "<div><picture><source srcset=\"1.webp\"><img src=\"1.png\"></picture>x<img src=\"2\">...</div>",
+    },
+    {
+      "<div><picture><source srcset=\"1.webp\"><img src=\"1.png\"></picture>x<img src=\"2\">y</div>",
+      100,
+      1,
+      "<div><picture><source srcset=\"1.webp\"><img src=\"1.png\"></picture>...</div>",
+    },
+    {
+      "<p>a<img src=\"1\">b</p>",
+      100,
+      2,
+      "<p>a<img src=\"1\">b</p>...",
+    },
+  }
+
+  for _, c := range cases {
+    opts := Options{MaxImages: c.maxImages}
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "...", opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"...\") with MaxImages=%d == %q, want %q", c.in, c.limit, c.maxImages, got, c.want)
+    }
+  }
+}
+
+// TestNormalizeEntityCase checks that entities written in the wrong case are
+// rewritten in their canonical case.
+func TestNormalizeEntityCase(t *testing.T) {
+  cases := []struct {
+    in    string
+    limit int
+    opts  Options
+    want  string
+  }{
+    {"<p>&COPY; 2015 &AMP; co</p>", 100, Options{}, "<p>&COPY; 2015 &AMP; co</p>"},
+    {"<p>&COPY; 2015 &AMP; co</p>", 100, Options{NormalizeEntityCase: true}, "<p>&copy; 2015 &amp; co</p>"},
+    {"<p>a&NBSP;b&Nbsp;c</p>", 100, Options{NormalizeEntityCase: true}, "<p>a&nbsp;b&nbsp;c</p>"},
+    {"<p>&Alpha;&alpha;&Eacute;&eacute;</p>", 100, Options{NormalizeEntityCase: true}, "<p>&Alpha;&alpha;&Eacute;&eacute;</p>"},
+    {"<p>&EACUTE;&ALPHA;&eACUTE;</p>", 100, Options{NormalizeEntityCase: true}, "<p>&EACUTE;&ALPHA;&eACUTE;</p>"},
+    {"<p>&FOO; &#X41; &LT;</p>", 100, Options{NormalizeEntityCase: true}, "<p>&FOO; &#X41; &lt;</p>"},
+    {"<p>a&ZWJ;b&NBSP;c</p>", 3, Options{NormalizeEntityCase: true}, "<p>a&zwj;b&nbsp;</p>"},
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "", c.opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"\") with %+v == %q, want %q", c.in, c.limit, c.opts, got, c.want)
+    }
+  }
+}
+
+// TestBalanceDelimiters checks that truncation does not leave a parenthesis
+// or quotation open.
+func TestBalanceDelimiters(t *testing.T) {
+  cases := []struct {
+    in    string
+    limit int
+    want  string
+  }{
+    {"<p>He left (quietly) and ran</p>", 15, "<p>He left (quietly)...</p>"},
+    {"<p>He left (quietly) and ran</p>", 10, "<p>He left ...</p>"},
+    {"<p>She said \"stop <i>now</i>\" twice</p>", 11, "<p>She said ...</p>"},
+    {"<p>She said &quot;stop&quot; twice</p>", 13, "<p>She said &quot;stop&quot;...</p>"},
+    {"<p>A (b [c] d) e</p>", 5, "<p>A ...</p>"},
+    {"<p>A (b) [c d] e</p>", 5, "<p>A (b) ...</p>"},
+    {"<p>“Quoted <b>text</b>” here</p>", 8, "<p>...</p>"},
+    {"<p>Unbalanced ( in source</p>", 100, "<p>Unbalanced ( in source</p>..."},
+    {"<p>x) y (z</p>", 4, "<p>x) y ...</p>"},
+    {"<p title=\"(\">a (b c</p>", 2, "<p title=\"(\">a ...</p>"},
+  }
+
+  for _, c := range cases {
+    opts := Options{BalanceDelimiters: true}
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "...", opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"...\") with BalanceDelimiters == %q, want %q", c.in, c.limit, got, c.want)
+    }
+  }
+}
+
+// TestVoidEndTags checks that stray end tags for void elements, such as
+// </br>, are dropped instead of being treated as unbalanced.
+func TestVoidEndTags(t *testing.T) {
+  cases := []struct {
+    in    string
+    limit int
+    want  string
+  }{
+    {"<p>a<br></br>b</p>", 100, "<p>a<br>b</p>"},
+    {"<p>a</br>b</p>", 100, "<p>ab</p>"},
+    {"</br><p>a</p>", 100, "<p>a</p>"},
+    {"<p><img src=\"x\"></img>a</BR>b</p>", 1, "<p><img src=\"x\">a</p>"},
+    {"<div><p>a</hr></p></input>b</div>", 100, "<div><p>a</p>b</div>"},
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtml([]byte(c.in), c.limit, "")
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtml(%q, %d, \"\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtml(%q, %d, \"\") == %q, want %q", c.in, c.limit, got, c.want)
+    }
+  }
+}
+
+// TestFinalMultiByteRune checks inputs ending in a multi-byte rune, complete
+// or cut short, on the plain, general and ASCIIOnly paths.
+func TestFinalMultiByteRune(t *testing.T) {
+  cases := []struct {
+    in    string
+    limit int
+    want  string
+  }{
+    {"abc日", 4, "abc日..."},
+    {"abc日", 3, "abc..."},
+    {"abc日", 100, "abc日..."},
+    {"abc\xe6\x97", 100, "abc\xe6\x97..."},
+    {"abc\xe6\x97", 4, "abc\xe6..."},
+    {"😀", 1, "😀..."},
+    {"<b>abc</b>日", 4, "<b>abc</b>日..."},
+    {"<b>abc</b>\xf0\x9f\x98", 100, "<b>abc</b>\xf0\x9f\x98..."},
+  }
+
+  for _, c := range cases {
+    for _, opts := range []Options{{}, {ASCIIOnly: true}} {
+      got, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "...", opts)
+      if err != nil {
+        t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+      }
+      if string(got) != c.want {
+        t.Errorf("TruncateHtmlWithOptions(%q, %d, \"...\") with %+v == %q, want %q", c.in, c.limit, opts, got, c.want)
+      }
+    }
+    got, err := TruncateHtml([]byte(c.in), c.limit, "...")
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtml(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if string(got) != c.want {
+      t.Errorf("TruncateHtml(%q, %d, \"...\") == %q, want %q", c.in, c.limit, got, c.want)
+    }
+  }
+}
+
+// TestWhitespaceOnly checks inputs made up only of whitespace. By default
+// none of it counts, so all of it is kept; with CountWhitespace it is cut at
+// maxlen like any other text.
+func TestWhitespaceOnly(t *testing.T) {
+  cases := []struct {
+    in    string
+    limit int
+    opts  Options
+    want  string
+  }{
+    {"     ", 2, Options{}, "     ..."},
+    {"     ", 2, Options{CountWhitespace: true}, "  ..."},
+    {"     ", 5, Options{CountWhitespace: true}, "     ..."},
+    {"     ", 5, Options{CountWhitespace: true, EllipsisOnlyWhenTruncated: true}, "     "},
+    {"     ", 2, Options{EllipsisOnlyWhenTruncated: true}, "     "},
+    {"\n\t \r\n", 3, Options{CountWhitespace: true}, "\n\t ..."},
+    {"<p>   </p>", 2, Options{}, "<p>   </p>..."},
+    {"<p>   </p>", 2, Options{CountWhitespace: true}, "<p>  ...</p>"},
+    {"<p>   </p>", 2, Options{RenderedWhitespace: true}, "<p>   </p>..."},
+    {"     ", 0, Options{CountWhitespace: true}, ""},
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "...", c.opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"...\") with %+v == %q, want %q", c.in, c.limit, c.opts, got, c.want)
+    }
+  }
+
+  // The fast path for input without markup agrees with the default.
+  if got, _ := TruncateHtml([]byte("     "), 2, "..."); string(got) != "     ..." {
+    t.Errorf("TruncateHtml(%q, 2, \"...\") == %q, want %q", "     ", got, "     ...")
+  }
+}
+
+// TestRespectHidden checks that the content of hidden elements doesn't count
+// but is still copied.
+func TestRespectHidden(t *testing.T) {
+  cases := []struct {
+    in    string
+    limit int
+    opts  Options
+    want  string
+  }{
+    {"<p>ab<span hidden>secret</span>cd</p>", 3, Options{}, "<p>ab<span hidden>s</span></p>"},
+    {"<p>ab<span hidden>secret</span>cd</p>", 3, Options{RespectHidden: true}, "<p>ab<span hidden>secret</span>c</p>"},
+    {"<p>ab<span HIDDEN=\"\"><b>x</b>y</span>cd</p>", 4, Options{RespectHidden: true}, "<p>ab<span HIDDEN=\"\"><b>x</b>y</span>cd</p>"},
+    {"<p>ab<div style=\"color: red; display : NONE !important\">secret</div>cd</p>", 3, Options{RespectHidden: true}, "<p>ab<div style=\"color: red; display : NONE !important\">secret</div>c</p>"},
+    {"<p>ab<div style=\"display:block\">xy</div>cd</p>", 3, Options{RespectHidden: true}, "<p>ab<div style=\"display:block\">x</div></p>"},
+    {"<p>ab<span data-hidden=\"1\">xy</span></p>", 3, Options{RespectHidden: true}, "<p>ab<span data-hidden=\"1\">x</span></p>"},
+    {"<p>ab<img hidden alt=\"long text\">cd</p>", 3, Options{RespectHidden: true, CountAttrText: []string{"alt"}}, "<p>ab<img hidden alt=\"long text\">c</p>"},
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "", c.opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"\") with %+v == %q, want %q", c.in, c.limit, c.opts, got, c.want)
+    }
+  }
+}
+
+// TestExactFitEllipsis documents what happens when the visible length equals
+// maxlen exactly. By default the limit is reached, so the ellipsis is added
+// even though nothing was dropped; EllipsisOnlyWhenTruncated leaves it out.
+func TestExactFitEllipsis(t *testing.T) {
+  cases := []struct {
+    in    string
+    limit int
+    opts  Options
+    want  string
+  }{
+    {"<b>12345</b>", 5, Options{}, "<b>12345...</b>"},
+    {"<b>12345</b>", 5, Options{EllipsisOnlyWhenTruncated: true}, "<b>12345</b>"},
+    {"<b>12345</b><br><!-- c -->", 5, Options{}, "<b>12345...</b>"},
+    {"<b>12345</b><br><!-- c -->", 5, Options{EllipsisOnlyWhenTruncated: true}, "<b>12345</b><br><!-- c -->"},
+    {"<b>12345</b>6", 5, Options{}, "<b>12345...</b>"},
+    {"<b>12345</b>6", 5, Options{EllipsisOnlyWhenTruncated: true}, "<b>12345...</b>"},
+    {"12345", 5, Options{}, "12345..."},
+    {"12345", 5, Options{EllipsisOnlyWhenTruncated: true}, "12345"},
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "...", c.opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"...\") with %+v == %q, want %q", c.in, c.limit, c.opts, got, c.want)
+    }
+  }
+
+  if got, _ := TruncateHtml([]byte("<b>12345</b>"), 5, "..."); string(got) != "<b>12345...</b>" {
+    t.Errorf("TruncateHtml(%q, 5, \"...\") == %q, want %q", "<b>12345</b>", got, "<b>12345...</b>")
+  }
+}
+
+// TestConsecutiveEntities checks that entities with no text between them
+// count as one character each and are never split.
+func TestConsecutiveEntities(t *testing.T) {
+  cases := []struct {
+    in    string
+    limit int
+    want  string
+  }{
+    {"&amp;&lt;&gt;", 1, "&amp;"},
+    {"&amp;&lt;&gt;", 2, "&amp;&lt;"},
+    {"&amp;&lt;&gt;", 3, "&amp;&lt;&gt;"},
+    {"<b>&amp;&lt;&gt;</b>x", 2, "<b>&amp;&lt;</b>"},
+    {"&#60;&#x3E;&quot;", 2, "&#60;&#x3E;"},
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtml([]byte(c.in), c.limit, "")
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtml(%q, %d, \"\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtml(%q, %d, \"\") == %q, want %q", c.in, c.limit, got, c.want)
+    }
+  }
+
+  if n := VisibleLength([]byte("&amp;&lt;&gt;")); n != 3 {
+    t.Errorf("VisibleLength(%q) == %d, want 3", "&amp;&lt;&gt;", n)
+  }
+}
+
+// TestImpliedEndTags checks that elements whose end tag was left out, such as
+// an li followed by another li, are not closed twice.
+func TestImpliedEndTags(t *testing.T) {
+  cases := []struct {
+    in    string
+    limit int
+    want  string
+  }{
+    {"<ul><li>a<li>b", 100, "<ul><li>a<li>b...</li></ul>"},
+    {"<ul><li>a<li>b<li>c</ul>", 2, "<ul><li>a<li>b...</li></ul>"},
+    {"<ul><li>a<li>b</ul><p>after</p>", 100, "<ul><li>a<li>b</ul><p>after</p>..."},
+    {"<ul><li>a<ul><li>b<li>c</ul><li>d</ul>", 3, "<ul><li>a<ul><li>b<li>c...</li></ul></li></ul>"},
+    {"<div><p>one<p>two</div>", 100, "<div><p>one<p>two</div>..."},
+    {"<div><p>one<p>two</div>", 5, "<div><p>one<p>tw...</p></div>"},
+    {"<dl><dt>Term<dd>Def<dt>Next<dd>More</dl>", 10, "<dl><dt>Term<dd>Def<dt>Nex...</dt></dl>"},
+    {"<table><tr><td>1<td>2<tr><td>3</table>", 2, "<table><tr><td>1<td>2...</td></tr></table>"},
+    {"<select><option>a<option>b</select>", 1, "<select><option>a...</option></select>"},
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtml([]byte(c.in), c.limit, "...")
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtml(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtml(%q, %d, \"...\") == %q, want %q", c.in, c.limit, got, c.want)
+    }
+  }
+
+  // An end tag left over from an element closed by an implied end tag is
+  // dropped, even when the input closes the nested elements explicitly.
+  stray := []struct {
+    in    string
+    limit int
+    want  string
+  }{
+    {"<p><p>x</p></p>", 100, "<p><p>x</p>..."},
+    {"<li><li>x</li></li>", 100, "<li><li>x</li>..."},
+    {"<div><p>a<p>b</p></p></div>", 100, "<div><p>a<p>b</p></div>..."},
+    {"<div><p>a<p>b</p></p></div>", 1, "<div><p>a...</p></div>"},
+  }
+  for _, c := range stray {
+    out, err := TruncateHtml([]byte(c.in), c.limit, "...")
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtml(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtml(%q, %d, \"...\") == %q, want %q", c.in, c.limit, got, c.want)
+    }
+    if _, err := WouldTruncate([]byte(c.in), c.limit); err != nil {
+      t.Errorf("Got error calling WouldTruncate(%q, %d). Error: %s", c.in, c.limit, err.Error())
+    }
+    if _, _, err := TruncateHtmlWithRemainder([]byte(c.in), c.limit, "..."); err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithRemainder(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if _, err := TruncateHtmlElementBoundary([]byte(c.in), c.limit, "..."); err != nil {
+      t.Errorf("Got error calling TruncateHtmlElementBoundary(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+  }
+
+  // An end tag that matches no open element is still unbalanced.
+  if _, err := TruncateHtml([]byte("<ul><li>a</ol>"), 100, ""); err != UnbalancedTagsError {
+    t.Errorf("TruncateHtml(%q, 100, \"\") returned error %v, want %v", "<ul><li>a</ol>", err, UnbalancedTagsError)
+  }
+}
+
+// TestDisableEntities checks that with DisableEntities each '&' is an
+// ordinary character, so character references count and cut like text.
+func TestDisableEntities(t *testing.T) {
+  cases := []struct {
+    in    string
+    limit int
+    want  string
+  }{
+    {"a&amp;b", 6, "a&amp;"},
+    {"a&amp;b", 7, "a&amp;b"},
+    {"a&amp;b", 3, "a&a"},
+    {"<p>&lt;b&gt;</p>", 5, "<p>&lt;b</p>"},
+    {"Tom & Jerry", 4, "Tom &"},
+  }
+
+  opts := Options{DisableEntities: true}
+  for _, c := range cases {
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "", opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"\") with DisableEntities == %q, want %q", c.in, c.limit, got, c.want)
+    }
+
+    // ASCIIOnly takes the same path.
+    ascii := opts
+    ascii.ASCIIOnly = true
+    if out, _ := TruncateHtmlWithOptions([]byte(c.in), c.limit, "", ascii); string(out) != got {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"\") with ASCIIOnly == %q, want %q", c.in, c.limit, out, got)
+    }
+  }
+
+  if got, _ := TruncateHtml([]byte("a&amp;b"), 2, ""); string(got) != "a&amp;" {
+    t.Errorf("TruncateHtml(%q, 2, \"\") == %q, want %q", "a&amp;b", got, "a&amp;")
+  }
+}
+
+// TestAtomicSVG checks that with AtomicSVG an inline SVG image is kept whole
+// or left out, never cut between its shapes.
+func TestAtomicSVG(t *testing.T) {
+  svg := "<svg viewBox=\"0 0 10 10\"><g><circle cx=\"5\" cy=\"5\" r=\"4\"/>" +
+    "<text x=\"1\" y=\"6\">Hi there</text><path d=\"M0 0L10 10\"></path></g></svg>"
+  cases := []struct {
+    in     string
+    limit  int
+    atomic bool
+    want   string
+  }{
+    {
+      "<p>Logo " + svg + " done</p>",
+      8,
+      false,
+      "<p>Logo <svg viewBox=\"0 0 10 10\"><g><circle cx=\"5\" cy=\"5\" r=\"4\"/>" +
+        "<text x=\"1\" y=\"6\">Hi th...</text></g></svg></p>",
+    },
+    {
+      "<p>Logo " + svg + " done</p>",
+      8,
+      true,
+      "<p>Logo ...</p>",
+    },
+    {
+      "<p>Logo " + svg + " done</p>",
+      13,
+      true,
+      "<p>Logo " + svg + " do...</p>",
+    },
+    {
+      "<p>Logo " + svg + " done</p>",
+      100,
+      true,
+      "<p>Logo " + svg + " done</p>...",
+    },
+    {
+      "<p>Icon <svg><path d=\"M0 0\"/></svg> text</p>",
+      6,
+      true,
+      "<p>Icon <svg><path d=\"M0 0\"/></svg> te...</p>",
+    },
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "...", Options{AtomicSVG: c.atomic})
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"...\") with AtomicSVG %t == %q, want %q", c.in, c.limit, c.atomic, got, c.want)
+    }
+  }
+}
+
+// commentFreeHtml is a large document without any comments. Comments are
+// read as tokens in the main scan, so truncating it early reads only the
+// start of the input, however long it is.
+var commentFreeHtml = []byte(strings.Repeat("<p>Some <b>bold</b> text &amp; a <a href=\"/x\">link</a>.</p>\n", 10000))
+
+func BenchmarkCommentFreeShort(b *testing.B) {
+  b.ReportAllocs()
+  for i := 0; i < b.N; i++ {
+    TruncateHtml(commentFreeHtml, 100, "...")
+  }
+}
+
+func BenchmarkCommentFreeWhole(b *testing.B) {
+  b.ReportAllocs()
+  b.SetBytes(int64(len(commentFreeHtml)))
+  for i := 0; i < b.N; i++ {
+    TruncateHtml(commentFreeHtml, len(commentFreeHtml), "...")
+  }
+}
+
+// TestKeepPunctuation checks that with KeepPunctuation a word boundary cut
+// keeps punctuation with its word.
+func TestKeepPunctuation(t *testing.T) {
+  cases := []struct {
+    in    string
+    limit int
+    keep  bool
+    want  string
+  }{
+    {"He said (hello) and left", 12, false, "He said (hello..."},
+    {"He said (hello) and left", 12, true, "He said (hello)..."},
+    {"He said (hello) and left", 7, false, "He said (..."},
+    {"He said (hello) and left", 7, true, "He said..."},
+    {"She said \"stop.\" Then went", 12, true, "She said \"stop.\"..."},
+    {"She said \"stop.\" Then went", 13, false, "She said \"stop...."},
+    {"She said \"stop.\" Then went", 13, true, "She said \"stop.\"..."},
+    {"<p>See <b>this</b>.) Next</p>", 7, true, "<p>See <b>this</b>.)...</p>"},
+    {"<p>One, two, three</p>", 7, true, "<p>One, two,...</p>"},
+  }
+
+  for _, c := range cases {
+    opts := Options{WordBoundary: true, KeepPunctuation: c.keep}
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "...", opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"...\") with KeepPunctuation %t == %q, want %q", c.in, c.limit, c.keep, got, c.want)
+    }
+  }
+
+  // Without a word boundary mode the option has no effect.
+  if got, _ := TruncateHtmlWithOptions([]byte("(hello) there"), 6, "...", Options{KeepPunctuation: true}); string(got) != "(hello..." {
+    t.Errorf("TruncateHtmlWithOptions(%q, 6, \"...\") == %q, want %q", "(hello) there", got, "(hello...")
+  }
+}
+
+// TestMaxInputBytes checks that input longer than MaxInputBytes is rejected
+// and input up to it is truncated as usual.
+func TestMaxInputBytes(t *testing.T) {
+  in := []byte("<p>Hello <b>world</b></p>")
+  cases := []struct {
+    max  int
+    want string
+    err  error
+  }{
+    {0, "<p>Hello...</p>", nil},
+    {len(in), "<p>Hello...</p>", nil},
+    {len(in)-1, "", InputTooLargeError},
+    {1, "", InputTooLargeError},
+  }
+
+  for _, c := range cases {
+    opts := Options{MaxInputBytes: c.max}
+    out, err := TruncateHtmlWithOptions(in, 5, "...", opts)
+    if err != c.err {
+      t.Errorf("TruncateHtmlWithOptions(%q, 5, \"...\") with MaxInputBytes %d returned error %v, want %v", in, c.max, err, c.err)
+    }
+    if string(out) != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, 5, \"...\") with MaxInputBytes %d == %q, want %q", in, c.max, out, c.want)
+    }
+  }
+
+  // The limit is checked before the input is read, even when it is invalid.
+  opts := Options{MaxInputBytes: 4, SanitizeInvalidUTF8: true}
+  if _, err := TruncateHtmlWithOptions([]byte("<p>\xff</b>"), 5, "...", opts); err != InputTooLargeError {
+    t.Errorf("TruncateHtmlWithOptions with invalid input returned error %v, want %v", err, InputTooLargeError)
+  }
+}
+
+// TestAdjacentComments checks that comments with nothing between them are
+// each copied whole, before and after the cut.
+func TestAdjacentComments(t *testing.T) {
+  cases := []struct {
+    in    string
+    limit int
+    opts  Options
+    want  string
+  }{
+    {"<!--a--><!--b-->text", 2, Options{}, "<!--a--><!--b-->te..."},
+    {"<!--a--><!--b-->text", 4, Options{EllipsisOnlyWhenTruncated: true}, "<!--a--><!--b-->text"},
+    {"ab<!--a--><!--b-->cd", 2, Options{}, "ab..."},
+    {"ab<!--a--><!--b-->cd", 3, Options{}, "ab<!--a--><!--b-->c..."},
+    {"ab<!--a--><!--b-->cd<!--c--><!---->", 4, Options{}, "ab<!--a--><!--b-->cd..."},
+    {"ab<!--a--><!--b-->cd<!--c--><!---->", 4, Options{EllipsisOnlyWhenTruncated: true}, "ab<!--a--><!--b-->cd<!--c--><!---->"},
+    {"<p>ab<!--x--><!--y--></p><!--z-->cd", 3, Options{}, "<p>ab<!--x--><!--y--></p><!--z-->c..."},
+    {"<!--a--><!--b-->text<!--c--><!--d-->", 4, Options{StripComments: true}, "text..."},
+    {"<!--a--><!--<p>-->te<!--</p>-->xt", 3, Options{}, "<!--a--><!--<p>-->te<!--</p>-->x..."},
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "...", c.opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"...\") with %+v == %q, want %q", c.in, c.limit, c.opts, got, c.want)
+    }
+  }
+}
+
+// TestAttributeOrder checks that tags are copied byte for byte, keeping the
+// order of their attributes and the whitespace between them, and that the
+// options that rewrite tags change only what they are asked to.
+func TestAttributeOrder(t *testing.T) {
+  in := "<DIV  data-z=\"1\" id='main'\tclass=x\n hidden>" +
+    "<A title=\"a > b\" HREF=\"/p?q=1&amp;r=2\" rel=\"nofollow\">link</A>" +
+    "<IMG src=\"a.png\"   alt=\"\" width=10><br/><Input type=checkbox checked  />" +
+    "</DIV>"
+  cases := []struct {
+    opts Options
+    want string
+  }{
+    {
+      Options{EllipsisOnlyWhenTruncated: true},
+      in,
+    },
+    {
+      Options{EllipsisOnlyWhenTruncated: true, LowercaseTags: true},
+      "<div  data-z=\"1\" id='main'\tclass=x\n hidden>" +
+        "<a title=\"a > b\" HREF=\"/p?q=1&amp;r=2\" rel=\"nofollow\">link</a>" +
+        "<img src=\"a.png\"   alt=\"\" width=10><br/><input type=checkbox checked  />" +
+        "</div>",
+    },
+    {
+      Options{EllipsisOnlyWhenTruncated: true, VoidStyle: VoidHTML5},
+      "<DIV  data-z=\"1\" id='main'\tclass=x\n hidden>" +
+        "<A title=\"a > b\" HREF=\"/p?q=1&amp;r=2\" rel=\"nofollow\">link</A>" +
+        "<IMG src=\"a.png\"   alt=\"\" width=10><br><Input type=checkbox checked>" +
+        "</DIV>",
+    },
+    {
+      Options{EllipsisOnlyWhenTruncated: true, XHTML: true},
+      "<div  data-z=\"1\" id='main'\tclass=\"x\"\n hidden=\"hidden\">" +
+        "<a title=\"a > b\" href=\"/p?q=1&amp;r=2\" rel=\"nofollow\">link</a>" +
+        "<img src=\"a.png\"   alt=\"\" width=\"10\" /><br/><input type=\"checkbox\" checked=\"checked\"  />" +
+        "</div>",
+    },
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtmlWithOptions([]byte(in), 100, "...", c.opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, 100, \"...\"). Error: %s", in, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, 100, \"...\") with %+v == %q, want %q", in, c.opts, got, c.want)
+    }
+  }
+
+  // A truncated copy keeps the start tags it includes unchanged too, and
+  // the closing tags it adds match them.
+  want := "<DIV  data-z=\"1\" id='main'\tclass=x\n hidden>" +
+    "<A title=\"a > b\" HREF=\"/p?q=1&amp;r=2\" rel=\"nofollow\">li...</A></DIV>"
+  if got, _ := TruncateHtml([]byte(in), 2, "..."); string(got) != want {
+    t.Errorf("TruncateHtml(%q, 2, \"...\") == %q, want %q", in, got, want)
+  }
+}
+
+// TestVariationSelectors checks that a variation selector does not count and
+// is kept with the character before it.
+func TestVariationSelectors(t *testing.T) {
+  cases := []struct {
+    in    string
+    limit int
+    want  string
+  }{
+    {"a✉️b", 2, "a✉️..."},
+    {"a✉️b", 1, "a..."},
+    {"a✉️b", 3, "a✉️b..."},
+    {"<p>❤️❤︎❤</p>", 2, "<p>❤️❤︎...</p>"},
+    {"<p>Mail ✉&#xfe0f; sent</p>", 5, "<p>Mail ✉&#xfe0f;...</p>"},
+    {"<b>x✉</b>️y", 2, "<b>x✉...</b>"},
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtml([]byte(c.in), c.limit, "...")
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtml(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtml(%q, %d, \"...\") == %q, want %q", c.in, c.limit, got, c.want)
+    }
+
+    // The general path gives the same result as the plain text one.
+    if out, _ := TruncateHtmlWithOptions([]byte(c.in), c.limit, "...", Options{}); string(out) != got {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"...\") == %q, want %q", c.in, c.limit, out, got)
+    }
+  }
+
+  if n := VisibleLength([]byte("✉️ ✉&#xFE0F;")); n != 2 {
+    t.Errorf("VisibleLength of two emoji with variation selectors == %d, want 2", n)
+  }
+}
+
+// TestIsVisible checks that an IsVisible classifier decides which characters
+// count.
+func TestIsVisible(t *testing.T) {
+  // Count spaces, but not the asterisks used for decoration.
+  isVisible := func(r rune) bool {
+    return r != '*' && (unicode.IsPrint(r) || unicode.IsSpace(r))
+  }
+  cases := []struct {
+    in    string
+    limit int
+    want  string
+  }{
+    {"a b c d", 4, "a b ..."},
+    {"**a** b", 3, "**a** b..."},
+    {"<p>*x* <b>y z</b></p>", 4, "<p>*x* <b>y ...</b></p>"},
+    {"a&#42;b&nbsp;c", 3, "a&#42;b&nbsp;..."},
+  }
+
+  opts := Options{IsVisible: isVisible}
+  for _, c := range cases {
+    out, err := TruncateHtmlWithOptions([]byte(c.in), c.limit, "...", opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, %d, \"...\"). Error: %s", c.in, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, %d, \"...\") with IsVisible == %q, want %q", c.in, c.limit, got, c.want)
+    }
+  }
+
+  // Without a classifier the default rule applies.
+  if got, _ := TruncateHtmlWithOptions([]byte("a b c d"), 3, "...", Options{}); string(got) != "a b c..." {
+    t.Errorf("TruncateHtmlWithOptions(%q, 3, \"...\") == %q, want %q", "a b c d", got, "a b c...")
+  }
+}
+
+// TestCloserCase checks that the closing tags added by truncation match the
+// case of their start tags, or are lowercase with LowercaseTags.
+func TestCloserCase(t *testing.T) {
+  cases := []struct {
+    in    string
+    opts  Options
+    want  string
+  }{
+    {"<DIV><Span>Hello world</Span></DIV>", Options{}, "<DIV><Span>Hello...</Span></DIV>"},
+    {"<DIV><Span>Hello world</Span></DIV>", Options{LowercaseTags: true}, "<div><span>Hello...</span></div>"},
+    {"<DIV><Span>Hello world</Span></DIV>", Options{XHTML: true}, "<div><span>Hello...</span></div>"},
+    {"<div><span>Hello world</span></div>", Options{}, "<div><span>Hello...</span></div>"},
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtmlWithOptions([]byte(c.in), 5, "...", c.opts)
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithOptions(%q, 5, \"...\"). Error: %s", c.in, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithOptions(%q, 5, \"...\") with %+v == %q, want %q", c.in, c.opts, got, c.want)
+    }
+  }
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2015 Matt Borgerson
+// 
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// 
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+// 
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+
+package truncatehtml
+
+import (
+    "unicode"
+)
+
+// wideTable holds the characters with the East Asian Width property Wide (W)
+// or Fullwidth (F), which take up two columns on a display.
+var wideTable = &unicode.RangeTable{
+    R16: []unicode.Range16{
+        {0x1100, 0x115f, 1},  // Hangul Jamo initial consonants
+        {0x231a, 0x231b, 1},  // Watch, hourglass
+        {0x2329, 0x232a, 1},  // Angle brackets
+        {0x23e9, 0x23ec, 1},
+        {0x23f0, 0x23f3, 3},
+        {0x25fd, 0x25fe, 1},
+        {0x2614, 0x2615, 1},
+        {0x2648, 0x2653, 1},  // Zodiac symbols
+        {0x267f, 0x2693, 20},
+        {0x26a1, 0x26a1, 1},
+        {0x26aa, 0x26ab, 1},
+        {0x26bd, 0x26be, 1},
+        {0x26c4, 0x26c5, 1},
+        {0x26ce, 0x26d4, 6},
+        {0x26ea, 0x26ea, 1},
+        {0x26f2, 0x26f3, 1},
+        {0x26f5, 0x26fa, 5},
+        {0x26fd, 0x2705, 8},
+        {0x270a, 0x270b, 1},
+        {0x2728, 0x274c, 36},
+        {0x274e, 0x274e, 1},
+        {0x2753, 0x2755, 1},
+        {0x2757, 0x2757, 1},
+        {0x2795, 0x2797, 1},
+        {0x27b0, 0x27bf, 15},
+        {0x2b1b, 0x2b1c, 1},
+        {0x2b50, 0x2b55, 5},
+        {0x2e80, 0x303e, 1},  // CJK radicals, symbols and punctuation
+        {0x3041, 0x33ff, 1},  // Hiragana, Katakana, Bopomofo, CJK letters
+        {0x3400, 0x4dbf, 1},  // CJK Unified Ideographs Extension A
+        {0x4e00, 0x9fff, 1},  // CJK Unified Ideographs
+        {0xa000, 0xa4cf, 1},  // Yi
+        {0xa960, 0xa97f, 1},  // Hangul Jamo Extended-A
+        {0xac00, 0xd7a3, 1},  // Hangul Syllables
+        {0xf900, 0xfaff, 1},  // CJK Compatibility Ideographs
+        {0xfe10, 0xfe19, 1},  // Vertical forms
+        {0xfe30, 0xfe6f, 1},  // CJK compatibility and small form variants
+        {0xff00, 0xff60, 1},  // Fullwidth forms
+        {0xffe0, 0xffe6, 1},  // Fullwidth signs
+    },
+    R32: []unicode.Range32{
+        {0x16fe0, 0x16fe4, 1},
+        {0x17000, 0x18cff, 1},  // Tangut
+        {0x1b000, 0x1b2ff, 1},  // Kana supplement and extensions
+        {0x1f004, 0x1f0cf, 203},
+        {0x1f18e, 0x1f18e, 1},
+        {0x1f191, 0x1f19a, 1},
+        {0x1f200, 0x1f202, 1},
+        {0x1f210, 0x1f23b, 1},
+        {0x1f240, 0x1f248, 1},
+        {0x1f250, 0x1f251, 1},
+        {0x1f260, 0x1f265, 1},
+        {0x1f300, 0x1f64f, 1},  // Pictographs and emoticons
+        {0x1f680, 0x1f6ff, 1},  // Transport and map symbols
+        {0x1f7e0, 0x1f7eb, 1},
+        {0x1f90c, 0x1f9ff, 1},  // Supplemental symbols and pictographs
+        {0x1fa70, 0x1faff, 1},
+        {0x20000, 0x2fffd, 1},  // CJK Unified Ideographs Extensions B-F
+        {0x30000, 0x3fffd, 1},  // CJK Unified Ideographs Extension G
+    },
+}
+
+// isWide reports whether r is an East Asian wide or fullwidth character.
+func isWide(r rune) bool {
+    return unicode.Is(wideTable, r)
+}
+
+// TruncateHtmlWidth truncates buf to fit within maxWidth, where each visible
+// character takes up widthOf(character), for example its width in pixels in
+// the font it will be shown in. Runs of whitespace between words count once,
+// with the width of a space, as a browser renders them. If widthOf is nil,
+// every character has a width of one. A character that would go past
+// maxWidth is left out.
+func TruncateHtmlWidth(buf []byte, maxWidth int, widthOf func(rune) int, ellipsis string) ([]byte, error) {
+    if widthOf == nil {
+        widthOf = func(rune) int { return 1 }
+    }
+    t := newTruncator(buf, maxWidth, Options{RenderedWhitespace: true})
+    t.widthOf = widthOf
+    result, err := t.run(ellipsis)
+    if err != nil {
+        return nil, err
+    }
+    return result.output, nil
+}
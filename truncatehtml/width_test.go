@@ -0,0 +1,95 @@
+package truncatehtml
+
+import "testing"
+
+// TestTruncateHtmlWidth checks truncation to a rendered width given by a
+// width function, and that a nil function counts each character as one.
+func TestTruncateHtmlWidth(t *testing.T) {
+  // Narrow letters are 4 pixels wide, wide ones 12 and the rest 8.
+  widthOf := func(r rune) int {
+    switch r {
+    case 'i', 'l', '.', ' ':
+      return 4
+    case 'm', 'w', 'M', 'W':
+      return 12
+    }
+    return 8
+  }
+
+  cases := []struct {
+      in string
+      maxWidth int
+      widthOf func(rune) int
+      want string
+  }{
+    {
+      "<p>illicit</p>",
+      28,
+      widthOf,
+      "<p>illici...</p>",
+    },
+    {
+      "<p>mammoth</p>",
+      32,
+      widthOf,
+      "<p>mam...</p>",
+    },
+    {
+      "<p>mammoth</p>",
+      31,
+      widthOf,
+      "<p>ma...</p>",
+    },
+    {
+      "<p>mi <b>mi</b></p>",
+      36,
+      widthOf,
+      "<p>mi <b>mi...</b></p>",
+    },
+    {
+      "<p>mi\n\n   <b>mi</b></p>",
+      36,
+      widthOf,
+      "<p>mi\n\n   <b>mi...</b></p>",
+    },
+    {
+      "<p>mi <b>mi</b></p>",
+      32,
+      widthOf,
+      "<p>mi <b>m...</b></p>",
+    },
+    {
+      "<p>mi <b>mi</b></p>",
+      20,
+      widthOf,
+      "<p>mi <b>...</b></p>",
+    },
+    {
+      "<p>mammoth</p>",
+      3,
+      nil,
+      "<p>mam...</p>",
+    },
+    {
+      "<p>mammoth</p>",
+      0,
+      widthOf,
+      "",
+    },
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtmlWidth([]byte(c.in), c.maxWidth, c.widthOf, "...")
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWidth(%q, %d, widthOf, \"...\"). Error: %s", c.in, c.maxWidth, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWidth(%q, %d, widthOf, \"...\") == %q, want %q", c.in, c.maxWidth, got, c.want)
+    }
+  }
+
+  if _, err := TruncateHtmlWidth([]byte("<p>Bad</b>"), 100, widthOf, ""); err != UnbalancedTagsError {
+    t.Errorf("TruncateHtmlWidth with unbalanced tags returned error %v, want %v", err, UnbalancedTagsError)
+  }
+}
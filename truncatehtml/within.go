@@ -0,0 +1,94 @@
+// Copyright (c) 2015 Matt Borgerson
+// 
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// 
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+// 
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package truncatehtml
+
+import (
+    "math"
+    "strings"
+)
+
+// TruncateHtmlWithin truncates only the contents of the first element in buf
+// matching selector, leaving everything outside that element unchanged. The
+// selector is a tag name, a class name preceded by a period, or both, as in
+// "div.article-body". The ellipsis is added only if content was dropped. If
+// no element matches, buf is returned unchanged.
+func TruncateHtmlWithin(buf []byte, selector string, maxlen int, ellipsis string) ([]byte, error) {
+    name, class, _ := strings.Cut(selector, ".")
+    name = strings.ToLower(name)
+
+    // Find the start of the element's contents, then its end tag.
+    t := newTruncator(buf, math.MaxInt, Options{})
+    t.discard = true
+    start, depth, tagStart := -1, 0, 0
+    for t.pos < len(buf) {
+        tok := readToken(buf, t.pos)
+        if err := t.step(); err != nil {
+            return nil, err
+        }
+        if tok.kind == startTagToken && len(t.stack) > 0 && t.stack[len(t.stack)-1].name == tok.name &&
+           matchesSelector(tok, buf[tok.start:tok.end], name, class) {
+            start, depth, tagStart = tok.end, len(t.stack), tok.start
+            break
+        }
+    }
+    if start < 0 {
+        return append([]byte{}, buf...), nil
+    }
+    end := len(buf)
+    for t.pos < len(buf) {
+        pos := t.pos
+        if err := t.step(); err != nil {
+            return nil, err
+        }
+        // The element ends when it is popped, by its end tag or by an
+        // implied end such as a sibling <p> starting.
+        if len(t.stack) < depth || t.stack[depth-1].start != tagStart {
+            end = pos
+            break
+        }
+    }
+
+    inner, err := TruncateHtmlWithOptions(buf[start:end], maxlen, ellipsis, Options{EllipsisOnlyWhenTruncated: true})
+    if err != nil {
+        return nil, err
+    }
+    output := make([]byte, 0, start+len(inner)+len(buf)-end)
+    output = append(output, buf[:start]...)
+    output = append(output, inner...)
+    return append(output, buf[end:]...), nil
+}
+
+// matchesSelector reports whether the start tag tok, whose bytes are raw, has
+// the given lowercase name and class. An empty name or class matches any.
+func matchesSelector(tok token, raw []byte, name, class string) bool {
+    if name != "" && tok.name != name {
+        return false
+    }
+    if class == "" {
+        return true
+    }
+    for _, word := range strings.Fields(attributeValue(raw, "class")) {
+        if word == class {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,91 @@
+package truncatehtml
+
+import "testing"
+
+// TestTruncateHtmlWithin checks that only the first element matching the
+// selector is truncated, and the rest of the page is kept.
+func TestTruncateHtmlWithin(t *testing.T) {
+  page := "<header><h1>Title text</h1></header>" +
+    "<div class=\"sidebar\"><p>Side text</p></div>" +
+    "<div class=\"main article-body\"><p>Hello <b>world</b></p><div><p>More text</p></div></div>" +
+    "<footer>Footer text</footer>"
+  cases := []struct {
+      in string
+      selector string
+      limit int
+      want string
+  }{
+    {
+      page,
+      "div.article-body",
+      7,
+      "<header><h1>Title text</h1></header>" +
+        "<div class=\"sidebar\"><p>Side text</p></div>" +
+        "<div class=\"main article-body\"><p>Hello <b>wo...</b></p></div>" +
+        "<footer>Footer text</footer>",
+    },
+    {
+      page,
+      ".article-body",
+      12,
+      "<header><h1>Title text</h1></header>" +
+        "<div class=\"sidebar\"><p>Side text</p></div>" +
+        "<div class=\"main article-body\"><p>Hello <b>world</b></p><div><p>Mo...</p></div></div>" +
+        "<footer>Footer text</footer>",
+    },
+    {
+      page,
+      "DIV.article-body",
+      100,
+      page,
+    },
+    {
+      page,
+      "div",
+      4,
+      "<header><h1>Title text</h1></header>" +
+        "<div class=\"sidebar\"><p>Side...</p></div>" +
+        "<div class=\"main article-body\"><p>Hello <b>world</b></p><div><p>More text</p></div></div>" +
+        "<footer>Footer text</footer>",
+    },
+    {
+      page,
+      "div.article",
+      4,
+      page,
+    },
+    {
+      "<section class=\"x\"><p>Unclosed text",
+      "section.x",
+      4,
+      "<section class=\"x\"><p>Uncl...</p>",
+    },
+    {
+      "<div><p class=lead>Intro text here<p>Second paragraph</div>",
+      "p.lead",
+      5,
+      "<div><p class=lead>Intro...<p>Second paragraph</div>",
+    },
+    {
+      "<ul><li class=x>abc<li class=x>def</ul>",
+      "li.x",
+      1,
+      "<ul><li class=x>a...<li class=x>def</ul>",
+    },
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtmlWithin([]byte(c.in), c.selector, c.limit, "...")
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWithin(%q, %q, %d, \"...\"). Error: %s", c.in, c.selector, c.limit, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWithin(%q, %q, %d, \"...\") == %q, want %q", c.in, c.selector, c.limit, got, c.want)
+    }
+  }
+
+  if _, err := TruncateHtmlWithin([]byte("<div class=\"x\"><p>Bad</b></div>"), "div.x", 10, ""); err != UnbalancedTagsError {
+    t.Errorf("TruncateHtmlWithin with unbalanced tags returned error %v, want %v", err, UnbalancedTagsError)
+  }
+}
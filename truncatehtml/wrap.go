@@ -0,0 +1,53 @@
+// Copyright (c) 2015 Matt Borgerson
+// 
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// 
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+// 
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+
+package truncatehtml
+
+import (
+    "math"
+)
+
+// TruncateHtmlWrapped truncates buf to fit a fixed-width display of maxLines
+// lines of maxChars visible characters each, such as a terminal. Text wraps
+// onto a new line once a line holds maxChars characters, and <br> tags and
+// the ends of block-level elements such as </p> force a line break. Output
+// stops before the first character or block that would start line maxLines+1.
+func TruncateHtmlWrapped(buf []byte, maxChars, maxLines int, ellipsis string) ([]byte, error) {
+    if maxChars <= 0 || maxLines <= 0 {
+        return []byte{}, nil
+    }
+
+    // The display can't hold more than maxChars*maxLines characters, but
+    // that product may not fit in an int.
+    maxlen := math.MaxInt
+    if maxChars <= math.MaxInt/maxLines {
+        maxlen = maxChars*maxLines
+    }
+
+    t := newTruncator(buf, maxlen, Options{})
+    t.lineWidth = maxChars
+    t.maxLines = maxLines
+    result, err := t.run(ellipsis)
+    if err != nil {
+        return nil, err
+    }
+    return result.output, nil
+}
@@ -0,0 +1,74 @@
+package truncatehtml
+
+import "testing"
+
+// TestTruncateHtmlWrapped checks that line breaks from markup use up the line
+// budget along with wrapped text.
+func TestTruncateHtmlWrapped(t *testing.T) {
+  cases := []struct {
+      in string
+      chars int
+      lines int
+      want string
+  }{
+    {
+      "abcdefghij",
+      4,
+      2,
+      "abcdefgh",
+    },
+    {
+      "ab<br>cd<br>ef",
+      4,
+      2,
+      "ab<br>cd<br>",
+    },
+    {
+      "<p>ab</p><p>cd</p><p>ef</p>",
+      4,
+      2,
+      "<p>ab</p><p>cd</p>",
+    },
+    {
+      "<p>abcdef</p><p>gh</p>",
+      4,
+      2,
+      "<p>abcdef</p>",
+    },
+    {
+      "<p>abcdef</p><p>gh</p>",
+      4,
+      3,
+      "<p>abcdef</p><p>gh</p>",
+    },
+    {
+      "<p>a</p><br><p>b</p>",
+      4,
+      2,
+      "<p>a</p><br>",
+    },
+    {
+      "<p>ab</p><p>cd</p><p>ef</p>",
+      10,
+      2,
+      "<p>ab</p><p>cd</p>",
+    },
+    {
+      "<p>ab</p>",
+      0,
+      2,
+      "",
+    },
+  }
+
+  for _, c := range cases {
+    out, err := TruncateHtmlWrapped([]byte(c.in), c.chars, c.lines, "")
+    got := string(out)
+    if err != nil {
+      t.Errorf("Got error calling TruncateHtmlWrapped(%q, %d, %d, \"\"). Error: %s", c.in, c.chars, c.lines, err.Error())
+    }
+    if got != c.want {
+      t.Errorf("TruncateHtmlWrapped(%q, %d, %d, \"\") == %q, want %q", c.in, c.chars, c.lines, got, c.want)
+    }
+  }
+}